@@ -2,6 +2,8 @@ package cli
 
 import (
 	"docker/cli/command"
+	"docker/image"
+	"docker/storage"
 	"log/slog"
 	"os"
 
@@ -12,16 +14,48 @@ func NewCli() *cli.App {
 	app := &cli.App{
 		Name: "easydocker",
 		Commands: []*cli.Command{
+			command.Create,
 			command.Run,
+			command.Start,
+			command.Restart,
 			command.Ps,
 			command.Stop,
+			command.Kill,
+			command.Pause,
+			command.Unpause,
+			command.Update,
+			command.Rm,
 			command.Exec,
+			command.Attach,
+			command.Logs,
+			command.Stats,
+			command.Inspect,
 			command.Init,
+			command.Shim,
+			command.Pull,
+			command.Images,
+			command.Rmi,
+			command.Tag,
+			command.Login,
+			command.Logout,
+		},
+		Flags: []cli.Flag{
+			&cli.IntFlag{
+				Name:  "max-concurrent-downloads",
+				Usage: "max number of layers downloaded at the same time",
+				Value: image.MaxConcurrentDownloads,
+			},
+			&cli.StringFlag{
+				Name:  "storage-driver",
+				Usage: "storage driver for new containers (overlay, vfs)",
+			},
 		},
 		//命令执行前的钩子
 		Before: func(ctx *cli.Context) error {
 			handler := slog.NewJSONHandler(os.Stdout, nil)
 			slog.SetDefault(slog.New(handler))
+			image.MaxConcurrentDownloads = ctx.Int("max-concurrent-downloads")
+			storage.DefaultDriver = ctx.String("storage-driver")
 			return nil
 		},
 	}
@@ -0,0 +1,42 @@
+package command
+
+import (
+	"docker/container"
+	"errors"
+
+	"github.com/urfave/cli/v2"
+)
+
+var Attach = &cli.Command{
+	Name:      "attach",
+	Usage:     "attach local standard input, output, and error streams to a running container",
+	ArgsUsage: "container",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "no-stdin",
+			Usage: "do not attach stdin",
+		},
+		&cli.StringFlag{
+			Name:  "detach-keys",
+			Usage: "key sequence for detaching from the container",
+			Value: container.DefaultDetachKeys,
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if ctx.Args().Len() != 1 {
+			return errors.New("attach requires exactly one container")
+		}
+		code, err := container.Attach(ctx.Args().First(), &container.AttachOptions{
+			NoStdin:    ctx.Bool("no-stdin"),
+			DetachKeys: ctx.String("detach-keys"),
+		})
+		if err != nil {
+			return err
+		}
+		//和docker一样，容器退出的话attach的退出码就是容器的
+		if code != 0 {
+			return cli.Exit("", code)
+		}
+		return nil
+	},
+}
@@ -0,0 +1,200 @@
+package command
+
+import (
+	"docker/container"
+	"docker/isolation"
+	"docker/storage"
+	"fmt"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// containerFlags run和create共用的参数
+var containerFlags = append([]cli.Flag{
+	&cli.StringFlag{
+		Name:  "name",
+		Usage: "container name",
+	},
+	&cli.StringFlag{
+		Name:  "image",
+		Usage: "container image",
+		Value: "busybox",
+	},
+	&cli.BoolFlag{
+		Name:    "interactive",
+		Aliases: []string{"i"},
+		Usage:   "keep stdin open",
+	},
+	&cli.BoolFlag{
+		Name:    "tty",
+		Aliases: []string{"t"},
+		Usage:   "allocate a pseudo-TTY",
+	},
+	&cli.BoolFlag{
+		Name:  "it",
+		Usage: "same as -i -t",
+	},
+	&cli.StringSliceFlag{
+		Name:  "log-opt",
+		Usage: "log driver options (max-size=10m, max-file=3)",
+	},
+	&cli.StringFlag{
+		Name:  "platform",
+		Usage: "pull image for platform os/arch[/variant]",
+	},
+	&cli.StringFlag{
+		Name:  "stop-signal",
+		Usage: "signal to stop the container (default: image StopSignal or SIGTERM)",
+	},
+	&cli.StringSliceFlag{
+		Name:    "label",
+		Aliases: []string{"l"},
+		Usage:   "set metadata on the container (key=value)",
+	},
+	&cli.IntFlag{
+		Name:  "stop-timeout",
+		Usage: "seconds to wait before killing the container on stop (default 10)",
+		Value: -1,
+	},
+}, resourceFlags...)
+
+// resourceFlags run、create和update共用的资源限制
+var resourceFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "memory",
+		Aliases: []string{"m"},
+		Usage:   "memory limit (e.g. 512m, 1g)",
+	},
+	&cli.StringFlag{
+		Name:  "memory-swap",
+		Usage: "memory plus swap limit, -1 for unlimited swap",
+	},
+	&cli.StringFlag{
+		Name:  "memory-reservation",
+		Usage: "memory soft limit",
+	},
+	&cli.Float64Flag{
+		Name:  "cpus",
+		Usage: "number of CPUs",
+	},
+	&cli.Int64Flag{
+		Name:    "cpu-shares",
+		Aliases: []string{"c"},
+		Usage:   "CPU shares (relative weight)",
+	},
+	&cli.StringFlag{
+		Name:  "cpuset-cpus",
+		Usage: "CPUs in which to allow execution (0-3, 0,1)",
+	},
+	&cli.StringFlag{
+		Name:  "cpuset-mems",
+		Usage: "memory nodes in which to allow execution (0-3, 0,1)",
+	},
+	&cli.Int64Flag{
+		Name:  "pids-limit",
+		Usage: "tune container pids limit (-1 for unlimited)",
+	},
+	&cli.UintFlag{
+		Name:  "blkio-weight",
+		Usage: "block IO weight, between 10 and 1000",
+	},
+}
+
+// parseResources 大小和docker一样可以写512m、1g，memory-swap可以是-1
+func parseResources(ctx *cli.Context) (isolation.Resources, error) {
+	r := isolation.Resources{
+		CPUShares:  ctx.Int64("cpu-shares"),
+		CpusetCpus: ctx.String("cpuset-cpus"),
+		CpusetMems: ctx.String("cpuset-mems"),
+		PidsLimit:  ctx.Int64("pids-limit"),
+	}
+	sizes := []struct {
+		flag  string
+		value *int64
+	}{
+		{"memory", &r.Memory},
+		{"memory-swap", &r.MemorySwap},
+		{"memory-reservation", &r.MemoryReservation},
+	}
+	for _, s := range sizes {
+		value := ctx.String(s.flag)
+		if value == "" {
+			continue
+		}
+		if s.flag == "memory-swap" && value == "-1" {
+			*s.value = -1
+			continue
+		}
+		size, err := storage.ParseSize(value)
+		if err != nil {
+			return r, fmt.Errorf("invalid --%s,%v", s.flag, err)
+		}
+		*s.value = size
+	}
+	if cpus := ctx.Float64("cpus"); cpus != 0 {
+		if cpus < 0 {
+			return r, fmt.Errorf("invalid --cpus %v", cpus)
+		}
+		r.SetCPUs(cpus)
+	}
+	if weight := ctx.Uint("blkio-weight"); weight != 0 {
+		if weight > 1000 {
+			return r, fmt.Errorf("range of blkio weight is from 10 to 1000")
+		}
+		r.BlkioWeight = uint16(weight)
+	}
+	return r, nil
+}
+
+func runOptions(ctx *cli.Context) (*container.RunOptions, error) {
+	logConfig, err := parseLogOpts(ctx.StringSlice("log-opt"))
+	if err != nil {
+		return nil, err
+	}
+	labels := map[string]string{}
+	for _, l := range ctx.StringSlice("label") {
+		key, value, _ := strings.Cut(l, "=")
+		if key == "" {
+			return nil, fmt.Errorf("invalid label %q", l)
+		}
+		labels[key] = value
+	}
+	resources, err := parseResources(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &container.RunOptions{
+		Name:        ctx.String("name"),
+		Image:       ctx.String("image"),
+		Platform:    ctx.String("platform"),
+		Command:     ctx.Args().Slice(),
+		Interactive: ctx.Bool("interactive") || ctx.Bool("it"),
+		Tty:         ctx.Bool("tty") || ctx.Bool("it"),
+		LogConfig:   logConfig,
+		StopSignal:  ctx.String("stop-signal"),
+		StopTimeout: ctx.Int("stop-timeout"),
+		Labels:      labels,
+		Resources:   resources,
+	}, nil
+}
+
+var Create = &cli.Command{
+	Name:                   "create",
+	Usage:                  "create a new container without starting it",
+	ArgsUsage:              "[command [args...]]",
+	UseShortOptionHandling: true,
+	Flags:                  containerFlags,
+	Action: func(ctx *cli.Context) error {
+		opts, err := runOptions(ctx)
+		if err != nil {
+			return err
+		}
+		c, err := container.Create(opts)
+		if err != nil {
+			return err
+		}
+		fmt.Println(c.ID)
+		return nil
+	},
+}
@@ -8,14 +8,71 @@ import (
 )
 
 var Exec = &cli.Command{
-	Name:  "exec",
-	Usage: "execute command",
+	Name:                   "exec",
+	Usage:                  "execute a command in a running container",
+	ArgsUsage:              "container command [args...]",
+	UseShortOptionHandling: true,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "interactive",
+			Aliases: []string{"i"},
+			Usage:   "keep stdin open",
+		},
+		&cli.BoolFlag{
+			Name:    "tty",
+			Aliases: []string{"t"},
+			Usage:   "allocate a pseudo-TTY",
+		},
+		&cli.BoolFlag{
+			Name:  "it",
+			Usage: "same as -i -t",
+		},
+		&cli.BoolFlag{
+			Name:    "detach",
+			Aliases: []string{"d"},
+			Usage:   "run command in the background",
+		},
+		&cli.StringFlag{
+			Name:  "detach-keys",
+			Usage: "key sequence for detaching from the command",
+			Value: container.DefaultDetachKeys,
+		},
+		&cli.StringSliceFlag{
+			Name:    "env",
+			Aliases: []string{"e"},
+			Usage:   "set environment variables (KEY=VALUE, or KEY to take it from the host)",
+		},
+		&cli.StringFlag{
+			Name:    "user",
+			Aliases: []string{"u"},
+			Usage:   "username or UID (format: <name|uid>[:<group|gid>])",
+		},
+		&cli.StringFlag{
+			Name:    "workdir",
+			Aliases: []string{"w"},
+			Usage:   "working directory inside the container",
+		},
+	},
 	Action: func(ctx *cli.Context) error {
 		if ctx.Args().Len() < 2 {
 			return fmt.Errorf("empty id or command")
 		}
-		id := ctx.Args().First()
-		cmd := ctx.Args().Slice()[1:]
-		return container.Exec(id, cmd)
+		code, err := container.Exec(ctx.Args().First(), &container.ExecOptions{
+			Cmd:         ctx.Args().Slice()[1:],
+			Env:         ctx.StringSlice("env"),
+			User:        ctx.String("user"),
+			Workdir:     ctx.String("workdir"),
+			Interactive: ctx.Bool("interactive") || ctx.Bool("it"),
+			Tty:         ctx.Bool("tty") || ctx.Bool("it"),
+			Detach:      ctx.Bool("detach"),
+			DetachKeys:  ctx.String("detach-keys"),
+		})
+		if err != nil {
+			return err
+		}
+		if code != 0 {
+			return cli.Exit("", code)
+		}
+		return nil
 	},
 }
@@ -0,0 +1,31 @@
+package command
+
+import (
+	"encoding/json"
+	"strings"
+	"text/template"
+)
+
+// 和docker的--format一样，除了Go模板本身再加几个常用函数
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"join":  strings.Join,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"split": strings.Split,
+	"title": func(s string) string {
+		if s == "" {
+			return s
+		}
+		return strings.ToUpper(s[:1]) + s[1:]
+	},
+}
+
+func parseTemplate(format string) (*template.Template, error) {
+	//命令行里写的\t要当成真的tab
+	format = strings.ReplaceAll(format, `\t`, "\t")
+	return template.New("format").Funcs(templateFuncs).Parse(format)
+}
@@ -0,0 +1,15 @@
+package command
+
+import (
+	"docker/image"
+
+	"github.com/urfave/cli/v2"
+)
+
+var Images = &cli.Command{
+	Name:  "images",
+	Usage: "list images",
+	Action: func(ctx *cli.Context) error {
+		return image.ListImages()
+	},
+}
@@ -2,7 +2,6 @@ package command
 
 import (
 	"docker/isolation"
-	"docker/storage"
 	"fmt"
 
 	"github.com/urfave/cli/v2"
@@ -12,7 +11,7 @@ var Init = &cli.Command{
 	Name:  "init",
 	Usage: "init container process",
 	Action: func(ctx *cli.Context) error {
-		if err := isolation.InitContainer(storage.Root); err != nil {
+		if err := isolation.InitContainer(); err != nil {
 			return fmt.Errorf("fail to init container,%v", err)
 		}
 		return nil
@@ -0,0 +1,97 @@
+package command
+
+import (
+	"docker/container"
+	"docker/image"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v2"
+)
+
+var Inspect = &cli.Command{
+	Name:      "inspect",
+	Usage:     "return low-level information on containers or images",
+	ArgsUsage: "name|id [name|id...]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "format",
+			Aliases: []string{"f"},
+			Usage:   "format the output using the given Go template",
+		},
+		&cli.StringFlag{
+			Name:  "type",
+			Usage: "return JSON for specified type (container, image)",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if ctx.Args().Len() == 0 {
+			return errors.New("empty container or image")
+		}
+		kind := ctx.String("type")
+		if kind != "" && kind != "container" && kind != "image" {
+			return fmt.Errorf("invalid type %q, must be container or image", kind)
+		}
+
+		var objects []any
+		var errs []error
+		for _, name := range ctx.Args().Slice() {
+			obj, err := inspectObject(name, kind)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			objects = append(objects, obj)
+		}
+
+		if format := ctx.String("format"); format != "" {
+			tmpl, err := parseTemplate(format)
+			if err != nil {
+				return fmt.Errorf("invalid format,%v", err)
+			}
+			for _, obj := range objects {
+				if err := tmpl.Execute(os.Stdout, obj); err != nil {
+					return fmt.Errorf("fail to execute format,%v", err)
+				}
+				fmt.Println()
+			}
+			return errors.Join(errs...)
+		}
+
+		//和docker一样，找不到的也输出一个空数组
+		if objects == nil {
+			objects = []any{}
+		}
+		data, err := json.MarshalIndent(objects, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return errors.Join(errs...)
+	},
+}
+
+// inspectObject 没指定type的时候先找容器再找镜像
+func inspectObject(name, kind string) (any, error) {
+	if kind != "image" {
+		c, err := container.Inspect(name)
+		if err == nil {
+			return c, nil
+		}
+		if kind == "container" {
+			return nil, err
+		}
+	}
+	if kind != "container" {
+		i, err := image.Inspect(name)
+		if err == nil {
+			return i, nil
+		}
+		if kind == "image" {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("no such object: %s", name)
+}
@@ -0,0 +1,92 @@
+package command
+
+import (
+	"bufio"
+	"docker/image"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/term"
+)
+
+var Login = &cli.Command{
+	Name:      "login",
+	Usage:     "log in to a registry",
+	ArgsUsage: "[server]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "username",
+			Aliases: []string{"u"},
+			Usage:   "username",
+		},
+		&cli.StringFlag{
+			Name:    "password",
+			Aliases: []string{"p"},
+			Usage:   "password",
+		},
+		&cli.BoolFlag{
+			Name:  "password-stdin",
+			Usage: "read password from stdin",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		registry := ctx.Args().First()
+		username := ctx.String("username")
+		password := ctx.String("password")
+
+		if ctx.Bool("password-stdin") && username == "" {
+			return fmt.Errorf("--password-stdin requires --username")
+		}
+		reader := bufio.NewReader(os.Stdin)
+		if username == "" {
+			fmt.Print("Username: ")
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return fmt.Errorf("fail to read username,%v", err)
+			}
+			username = strings.TrimSpace(line)
+		}
+		if password == "" && !ctx.Bool("password-stdin") && term.IsTerminal(int(os.Stdin.Fd())) {
+			//终端里输密码不回显
+			fmt.Print("Password: ")
+			b, err := term.ReadPassword(int(os.Stdin.Fd()))
+			fmt.Println()
+			if err != nil {
+				return fmt.Errorf("fail to read password,%v", err)
+			}
+			password = string(b)
+		}
+		if password == "" {
+			if !ctx.Bool("password-stdin") {
+				fmt.Print("Password: ")
+			}
+			line, err := reader.ReadString('\n')
+			if err != nil && line == "" {
+				return fmt.Errorf("fail to read password,%v", err)
+			}
+			password = strings.TrimRight(line, "\r\n")
+		}
+
+		if err := image.Login(registry, username, password); err != nil {
+			return fmt.Errorf("fail to login,%v", err)
+		}
+		fmt.Println("Login Succeeded")
+		return nil
+	},
+}
+
+var Logout = &cli.Command{
+	Name:      "logout",
+	Usage:     "log out from a registry",
+	ArgsUsage: "[server]",
+	Action: func(ctx *cli.Context) error {
+		registry := ctx.Args().First()
+		if err := image.Logout(registry); err != nil {
+			return fmt.Errorf("fail to logout,%v", err)
+		}
+		fmt.Println("Removed login credentials")
+		return nil
+	},
+}
@@ -0,0 +1,120 @@
+package command
+
+import (
+	"docker/container"
+	"docker/storage"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+var Logs = &cli.Command{
+	Name:      "logs",
+	Usage:     "fetch the logs of a container",
+	ArgsUsage: "container",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "follow",
+			Aliases: []string{"f"},
+			Usage:   "follow log output",
+		},
+		&cli.StringFlag{
+			Name:  "tail",
+			Usage: "number of lines to show from the end of the logs",
+			Value: "all",
+		},
+		&cli.StringFlag{
+			Name:  "since",
+			Usage: "show logs since timestamp (e.g. 2013-01-02T13:23:37Z) or relative (e.g. 42m)",
+		},
+		&cli.StringFlag{
+			Name:  "until",
+			Usage: "show logs before a timestamp (e.g. 2013-01-02T13:23:37Z) or relative (e.g. 42m)",
+		},
+		&cli.BoolFlag{
+			Name:    "timestamps",
+			Aliases: []string{"t"},
+			Usage:   "show timestamps",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if ctx.Args().Len() == 0 {
+			return fmt.Errorf("empty container id")
+		}
+		opts := container.LogsOptions{
+			Follow:     ctx.Bool("follow"),
+			Tail:       -1,
+			Timestamps: ctx.Bool("timestamps"),
+		}
+		if tail := ctx.String("tail"); tail != "all" {
+			n, err := strconv.Atoi(tail)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid tail %q", tail)
+			}
+			opts.Tail = n
+		}
+		var err error
+		if opts.Since, err = parseTime(ctx.String("since")); err != nil {
+			return err
+		}
+		if opts.Until, err = parseTime(ctx.String("until")); err != nil {
+			return err
+		}
+		return container.Logs(ctx.Args().First(), opts, os.Stdout, os.Stderr)
+	},
+}
+
+// parseTime 和docker一样，可以是时间戳、RFC3339，或者42m这种相对现在的时间
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if sec, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Unix(0, int64(sec*float64(time.Second))), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", value)
+}
+
+// parseLogOpts --log-opt max-size=10m --log-opt max-file=3
+func parseLogOpts(opts []string) (container.LogConfig, error) {
+	config := container.DefaultLogConfig
+	for _, opt := range opts {
+		key, value, ok := strings.Cut(opt, "=")
+		if !ok {
+			return config, fmt.Errorf("invalid log opt %q", opt)
+		}
+		switch key {
+		case "max-size":
+			if value == "-1" {
+				config.MaxSize = 0
+				continue
+			}
+			size, err := storage.ParseSize(value)
+			if err != nil {
+				return config, err
+			}
+			config.MaxSize = size
+		case "max-file":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return config, fmt.Errorf("invalid max-file %q", value)
+			}
+			config.MaxFile = n
+		default:
+			return config, fmt.Errorf("unknown log opt %q", key)
+		}
+	}
+	return config, nil
+}
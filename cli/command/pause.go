@@ -0,0 +1,49 @@
+package command
+
+import (
+	"docker/container"
+	"errors"
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+)
+
+var Pause = &cli.Command{
+	Name:      "pause",
+	Usage:     "pause all processes within containers",
+	ArgsUsage: "container [container...]",
+	Action: func(ctx *cli.Context) error {
+		if ctx.Args().Len() == 0 {
+			return errors.New("empty container id")
+		}
+		var errs []error
+		for _, id := range ctx.Args().Slice() {
+			if err := container.Pause(id); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			fmt.Println(id)
+		}
+		return errors.Join(errs...)
+	},
+}
+
+var Unpause = &cli.Command{
+	Name:      "unpause",
+	Usage:     "unpause all processes within containers",
+	ArgsUsage: "container [container...]",
+	Action: func(ctx *cli.Context) error {
+		if ctx.Args().Len() == 0 {
+			return errors.New("empty container id")
+		}
+		var errs []error
+		for _, id := range ctx.Args().Slice() {
+			if err := container.Unpause(id); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			fmt.Println(id)
+		}
+		return errors.Join(errs...)
+	},
+}
@@ -2,14 +2,134 @@ package command
 
 import (
 	"docker/container"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/urfave/cli/v2"
 )
 
+const defaultPsFormat = "table {{.ID}}\t{{.Image}}\t{{.Command}}\t{{.RunningFor}}\t{{.Status}}\t{{.Ports}}\t{{.Names}}"
+
+// psHeaders table格式的表头，和docker一样用字段名换成大写的列名
+var psHeaders = map[string]string{
+	"ID":         "CONTAINER ID",
+	"Image":      "IMAGE",
+	"Command":    "COMMAND",
+	"CreatedAt":  "CREATED AT",
+	"RunningFor": "CREATED",
+	"Ports":      "PORTS",
+	"State":      "STATE",
+	"Status":     "STATUS",
+	"Names":      "NAMES",
+	"Labels":     "LABELS",
+}
+
 var Ps = &cli.Command{
 	Name:  "ps",
-	Usage: "list all containers",
+	Usage: "list containers",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "all",
+			Aliases: []string{"a"},
+			Usage:   "show all containers (default shows just running)",
+		},
+		&cli.BoolFlag{
+			Name:    "quiet",
+			Aliases: []string{"q"},
+			Usage:   "only display container IDs",
+		},
+		&cli.BoolFlag{
+			Name:  "no-trunc",
+			Usage: "don't truncate output",
+		},
+		&cli.StringSliceFlag{
+			Name:    "filter",
+			Aliases: []string{"f"},
+			Usage:   "filter output (status, name, label, ancestor, before, since)",
+		},
+		&cli.StringFlag{
+			Name:  "format",
+			Usage: "format output using a Go template, 'table', 'table TEMPLATE' or 'json'",
+		},
+	},
 	Action: func(ctx *cli.Context) error {
-		return container.ListContainers()
+		filters := map[string][]string{}
+		for _, f := range ctx.StringSlice("filter") {
+			key, value, ok := strings.Cut(f, "=")
+			if !ok {
+				return fmt.Errorf("bad format of filter (expected name=value): %s", f)
+			}
+			filters[key] = append(filters[key], value)
+		}
+		list, err := container.List(container.ListOptions{All: ctx.Bool("all"), Filters: filters})
+		if err != nil {
+			return err
+		}
+
+		noTrunc := ctx.Bool("no-trunc")
+		var rows []*container.Summary
+		for _, c := range list {
+			rows = append(rows, container.NewSummary(c, noTrunc))
+		}
+		if ctx.Bool("quiet") {
+			for _, r := range rows {
+				fmt.Println(r.ID)
+			}
+			return nil
+		}
+		return printRows(os.Stdout, rows, ctx.String("format"), defaultPsFormat, psHeaders)
 	},
 }
+
+// printRows ps和stats共用，json每行一个，table带表头，其他的当成Go模板
+func printRows[T any](out io.Writer, rows []T, format, defaultFormat string, headers map[string]string) error {
+	if format == "json" {
+		enc := json.NewEncoder(out)
+		for _, r := range rows {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if format == "" || format == "table" {
+		format = defaultFormat
+	}
+
+	table := false
+	if rest, ok := strings.CutPrefix(format, "table"); ok {
+		table = true
+		format = strings.TrimSpace(rest)
+	}
+	tmpl, err := parseTemplate(format)
+	if err != nil {
+		return fmt.Errorf("invalid format,%v", err)
+	}
+	if !table {
+		for _, r := range rows {
+			if err := tmpl.Execute(out, r); err != nil {
+				return err
+			}
+			fmt.Fprintln(out)
+		}
+		return nil
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	//表头就是用列名渲染一遍同一个模板
+	if err := tmpl.Execute(w, headers); err != nil {
+		return err
+	}
+	fmt.Fprintln(w)
+	for _, r := range rows {
+		if err := tmpl.Execute(w, r); err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,28 @@
+package command
+
+import (
+	"docker/image"
+	"errors"
+	"os"
+
+	"github.com/urfave/cli/v2"
+)
+
+var Pull = &cli.Command{
+	Name:      "pull",
+	Usage:     "pull image from registry",
+	ArgsUsage: "image[:tag]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "platform",
+			Usage: "pull image for platform os/arch[/variant]",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if ctx.Args().Len() == 0 {
+			return errors.New("empty image")
+		}
+		_, err := image.Pull(ctx.Args().First(), ctx.String("platform"), os.Stdout)
+		return err
+	},
+}
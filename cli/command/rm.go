@@ -0,0 +1,41 @@
+package command
+
+import (
+	"docker/container"
+	"errors"
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+)
+
+var Rm = &cli.Command{
+	Name:      "rm",
+	Usage:     "remove containers",
+	ArgsUsage: "container [container...]",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "force",
+			Aliases: []string{"f"},
+			Usage:   "force the removal of a running container",
+		},
+		&cli.BoolFlag{
+			Name:    "volumes",
+			Aliases: []string{"v"},
+			Usage:   "remove anonymous volumes associated with the container",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if ctx.Args().Len() == 0 {
+			return errors.New("empty container id")
+		}
+		var errs []error
+		for _, id := range ctx.Args().Slice() {
+			if err := container.Remove(id, ctx.Bool("force"), ctx.Bool("volumes")); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			fmt.Println(id)
+		}
+		return errors.Join(errs...)
+	},
+}
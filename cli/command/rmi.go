@@ -0,0 +1,46 @@
+package command
+
+import (
+	"docker/container"
+	"docker/image"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+var Rmi = &cli.Command{
+	Name:      "rmi",
+	Usage:     "remove images",
+	ArgsUsage: "image [image...]",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "force",
+			Aliases: []string{"f"},
+			Usage:   "remove even if used by containers",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if ctx.Args().Len() == 0 {
+			return errors.New("empty image")
+		}
+		force := ctx.Bool("force")
+		var errs []error
+		for _, name := range ctx.Args().Slice() {
+			ids, err := container.UsingImage(name)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if len(ids) > 0 && !force {
+				errs = append(errs, fmt.Errorf("image %s is being used by container %s", name, strings.Join(ids, ",")))
+				continue
+			}
+			if err := image.Remove(name, force); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	},
+}
@@ -8,33 +8,42 @@ import (
 )
 
 var Run = &cli.Command{
-	Name:  "run",
-	Usage: "run container",
-	Flags: []cli.Flag{
-		&cli.StringFlag{
-			Name:  "name",
-			Usage: "container name",
+	Name:                   "run",
+	Usage:                  "run container",
+	ArgsUsage:              "[command [args...]]",
+	UseShortOptionHandling: true,
+	Flags: append([]cli.Flag{
+		&cli.BoolFlag{
+			Name:    "detach",
+			Aliases: []string{"d"},
+			Usage:   "run container in background and print container ID",
 		},
 		&cli.StringFlag{
-			Name:  "image",
-			Usage: "container image",
-			Value: "busybox",
-		},
-		&cli.BoolFlag{
-			Name:  "it",
-			Usage: "interactive mode",
+			Name:  "detach-keys",
+			Usage: "key sequence for detaching from a container with a TTY",
+			Value: container.DefaultDetachKeys,
 		},
-	},
+	}, containerFlags...),
 	Action: func(ctx *cli.Context) error {
-		if ctx.Args().Len() == 0 {
-			return fmt.Errorf("empty args")
+		opts, err := runOptions(ctx)
+		if err != nil {
+			return err
 		}
-		cmd := ctx.Args().Slice()
-		interactive := ctx.Bool("it")
-		containers := ctx.String("name")
-		image := ctx.String("image")
+		opts.Detach = ctx.Bool("detach")
+		opts.DetachKeys = ctx.String("detach-keys")
 
-		_, ans := container.Run(containers, image, cmd, interactive)
-		return ans
+		c, code, err := container.Run(opts)
+		if err != nil {
+			return err
+		}
+		if opts.Detach {
+			fmt.Println(c.ID)
+			return nil
+		}
+		//和docker run一样，退出码就是容器的退出码
+		if code != 0 {
+			return cli.Exit("", code)
+		}
+		return nil
 	},
 }
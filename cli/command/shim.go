@@ -0,0 +1,26 @@
+package command
+
+import (
+	"docker/container"
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+)
+
+var Shim = &cli.Command{
+	Name:   "shim",
+	Usage:  "supervise a detached container",
+	Hidden: true,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "attach",
+			Usage: "wait for a client on the console before starting",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if ctx.Args().Len() == 0 {
+			return fmt.Errorf("empty container id")
+		}
+		return container.Shim(ctx.Args().First(), ctx.Bool("attach"))
+	},
+}
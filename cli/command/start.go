@@ -0,0 +1,57 @@
+package command
+
+import (
+	"docker/container"
+	"errors"
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+)
+
+var Start = &cli.Command{
+	Name:      "start",
+	Usage:     "start created or stopped containers",
+	ArgsUsage: "container [container...]",
+	Action: func(ctx *cli.Context) error {
+		if ctx.Args().Len() == 0 {
+			return errors.New("empty container id")
+		}
+		var errs []error
+		for _, id := range ctx.Args().Slice() {
+			if err := container.Start(id); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			fmt.Println(id)
+		}
+		return errors.Join(errs...)
+	},
+}
+
+var Restart = &cli.Command{
+	Name:      "restart",
+	Usage:     "restart containers",
+	ArgsUsage: "container [container...]",
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:    "time",
+			Aliases: []string{"t"},
+			Usage:   "seconds to wait before killing the container (default: container stop timeout)",
+			Value:   -1,
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if ctx.Args().Len() == 0 {
+			return errors.New("empty container id")
+		}
+		var errs []error
+		for _, id := range ctx.Args().Slice() {
+			if err := container.Restart(id, ctx.Int("time")); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			fmt.Println(id)
+		}
+		return errors.Join(errs...)
+	},
+}
@@ -0,0 +1,73 @@
+package command
+
+import (
+	"bytes"
+	"docker/container"
+	"docker/isolation"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+const defaultStatsFormat = "table {{.ID}}\t{{.Name}}\t{{.CPUPerc}}\t{{.MemUsage}}\t{{.MemPerc}}\t{{.NetIO}}\t{{.BlockIO}}\t{{.PIDs}}"
+
+var statsHeaders = map[string]string{
+	"ID":       "CONTAINER ID",
+	"Name":     "NAME",
+	"CPUPerc":  "CPU %",
+	"MemUsage": "MEM USAGE / LIMIT",
+	"MemPerc":  "MEM %",
+	"NetIO":    "NET I/O",
+	"BlockIO":  "BLOCK I/O",
+	"PIDs":     "PIDS",
+}
+
+// 和docker一样每秒刷新一次，cpu百分比就是这一秒里用了多少
+const statsInterval = time.Second
+
+var Stats = &cli.Command{
+	Name:      "stats",
+	Usage:     "display a live stream of container resource usage statistics",
+	ArgsUsage: "[container...]",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "no-stream",
+			Usage: "disable streaming stats and only pull the first result",
+		},
+		&cli.StringFlag{
+			Name:  "format",
+			Usage: "format output using a Go template, 'table', 'table TEMPLATE' or 'json'",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		collector := container.NewStatsCollector()
+		refs := ctx.Args().Slice()
+		//第一次只是记下cpu时间，算不出百分比
+		if _, err := collector.Collect(refs); err != nil {
+			return err
+		}
+		//终端上每次清屏重画，重定向到文件的话一帧接一帧往后写
+		redraw := isolation.IsTerminal(os.Stdout.Fd())
+		for {
+			time.Sleep(statsInterval)
+			entries, err := collector.Collect(refs)
+			if err != nil {
+				return err
+			}
+			var buf bytes.Buffer
+			if err := printRows(&buf, entries, ctx.String("format"), defaultStatsFormat, statsHeaders); err != nil {
+				return err
+			}
+			if ctx.Bool("no-stream") {
+				_, err := os.Stdout.Write(buf.Bytes())
+				return err
+			}
+			if redraw {
+				fmt.Print("\033[2J\033[H")
+			}
+			os.Stdout.Write(buf.Bytes())
+		}
+	},
+}
@@ -3,18 +3,68 @@ package command
 import (
 	"docker/container"
 	"errors"
+	"fmt"
 
 	"github.com/urfave/cli/v2"
 )
 
 var Stop = &cli.Command{
-	Name:  "stop",
-	Usage: "stop container",
+	Name:      "stop",
+	Usage:     "stop container",
+	ArgsUsage: "container [container...]",
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:    "time",
+			Aliases: []string{"t"},
+			Usage:   "seconds to wait before killing the container (default: container stop timeout)",
+			Value:   -1,
+		},
+		&cli.StringFlag{
+			Name:    "signal",
+			Aliases: []string{"s"},
+			Usage:   "signal to send to the container (default: container stop signal or SIGTERM)",
+		},
+	},
 	Action: func(ctx *cli.Context) error {
 		if ctx.Args().Len() == 0 {
 			return errors.New("empty command")
 		}
-		ID := ctx.Args().First()
-		return container.Stop(ID)
+		var errs []error
+		for _, id := range ctx.Args().Slice() {
+			if err := container.Stop(id, ctx.Int("time"), ctx.String("signal")); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			fmt.Println(id)
+		}
+		return errors.Join(errs...)
+	},
+}
+
+var Kill = &cli.Command{
+	Name:      "kill",
+	Usage:     "send a signal to the main process of containers",
+	ArgsUsage: "container [container...]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "signal",
+			Aliases: []string{"s"},
+			Usage:   "signal name or number",
+			Value:   "KILL",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if ctx.Args().Len() == 0 {
+			return errors.New("empty container id")
+		}
+		var errs []error
+		for _, id := range ctx.Args().Slice() {
+			if err := container.Kill(id, ctx.String("signal")); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			fmt.Println(id)
+		}
+		return errors.Join(errs...)
 	},
 }
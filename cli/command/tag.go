@@ -0,0 +1,20 @@
+package command
+
+import (
+	"docker/image"
+	"errors"
+
+	"github.com/urfave/cli/v2"
+)
+
+var Tag = &cli.Command{
+	Name:      "tag",
+	Usage:     "create a tag that refers to an image",
+	ArgsUsage: "source target",
+	Action: func(ctx *cli.Context) error {
+		if ctx.Args().Len() != 2 {
+			return errors.New("need source and target image")
+		}
+		return image.Tag(ctx.Args().Get(0), ctx.Args().Get(1))
+	},
+}
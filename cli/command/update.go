@@ -0,0 +1,51 @@
+package command
+
+import (
+	"docker/container"
+	"errors"
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+)
+
+var Update = &cli.Command{
+	Name:                   "update",
+	Usage:                  "update resource limits and restart policy of containers",
+	ArgsUsage:              "container [container...]",
+	UseShortOptionHandling: true,
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:  "restart",
+			Usage: "restart policy to store on the container (no, always, unless-stopped, on-failure[:max-retries])",
+		},
+	}, resourceFlags...),
+	Action: func(ctx *cli.Context) error {
+		if ctx.Args().Len() == 0 {
+			return errors.New("empty container id")
+		}
+		if ctx.NumFlags() == 0 {
+			return errors.New("you must provide one or more flags when using this command")
+		}
+		resources, err := parseResources(ctx)
+		if err != nil {
+			return err
+		}
+		opts := &container.UpdateOptions{
+			Resources:     resources,
+			RestartPolicy: ctx.String("restart"),
+		}
+		var errs []error
+		for _, id := range ctx.Args().Slice() {
+			changes, err := container.Update(id, opts)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			fmt.Println(id)
+			for _, c := range changes {
+				fmt.Printf("  %s: %s -> %s\n", c.Field, c.Old, c.New)
+			}
+		}
+		return errors.Join(errs...)
+	},
+}
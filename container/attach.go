@@ -0,0 +1,60 @@
+package container
+
+import (
+	"docker/isolation"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+type AttachOptions struct {
+	NoStdin    bool
+	DetachKeys string
+}
+
+// Attach 连到容器的console上，容器退出的话返回它的退出码，detach断开的返回0
+func Attach(ID string, opts *AttachOptions) (int, error) {
+	c, err := Lookup(ID)
+	if err != nil {
+		return -1, err
+	}
+	detachKeys, err := ParseDetachKeys(opts.DetachKeys)
+	if err != nil {
+		return -1, err
+	}
+	if c.State.Status == StatusPaused {
+		return -1, fmt.Errorf("cannot attach to a paused container, unpause it first")
+	}
+	if !c.State.Running() {
+		return -1, fmt.Errorf("cannot attach to a stopped container, start it first")
+	}
+
+	tty := c.Process != nil && c.Process.Tty
+	var stdin *os.File
+	if c.OpenStdin && !opts.NoStdin {
+		stdin = os.Stdin
+		if tty && !isolation.IsTerminal(stdin.Fd()) {
+			return -1, fmt.Errorf("the input device is not a TTY")
+		}
+	}
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: consolePath(c.ID), Net: "unix"})
+	if err != nil {
+		return -1, fmt.Errorf("fail to attach to container %s,%v", c.ID, err)
+	}
+	detached, err := attachConsole(conn, tty, stdin, os.Stdout, os.Stderr, detachKeys)
+	if err != nil || detached {
+		return 0, err
+	}
+	return attachedExit(c.ID)
+}
+
+// attachedExit console断开就是容器退出了，supervisor先记下退出码再断开，这里保险再等一下
+func attachedExit(id string) (int, error) {
+	waitStopped(id, 10*time.Second)
+	c, err := GetContainer(id)
+	if err != nil {
+		return -1, err
+	}
+	return c.State.ExitCode, nil
+}
@@ -0,0 +1,372 @@
+package container
+
+import (
+	"docker/isolation"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 看管容器的进程(shim或者前台run的CLI)在这个unix socket上转发容器的输入输出，attach就是连到这上面
+const consoleSock = "console.sock"
+
+// DefaultDetachKeys 和docker一样，先按ctrl-p再按ctrl-q断开，容器接着跑
+const DefaultDetachKeys = "ctrl-p,ctrl-q"
+
+// 两个方向都是1字节类型+4字节长度+内容，tty的输出都算stdout
+const (
+	frameStdin  byte = 0
+	frameResize byte = 1
+	frameStdout byte = 2
+	frameStderr byte = 3
+	//一次最多这么大，防止乱发的长度把shim撑爆
+	maxFrameSize = 1 << 20
+)
+
+var errDetached = errors.New("detached")
+
+func consolePath(id string) string {
+	return path.Join(ContainerRoot, id, consoleSock)
+}
+
+func writeFrame(w io.Writer, typ byte, payload []byte) error {
+	frame := make([]byte, 5, 5+len(payload))
+	frame[0] = typ
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+	_, err := w.Write(append(frame, payload...))
+	return err
+}
+
+func readFrame(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(header[1:])
+	if n > maxFrameSize {
+		return 0, nil, fmt.Errorf("frame too large")
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+// consoleServer 容器的输出广播给所有连着的客户端，客户端的输入交给容器
+type consoleServer struct {
+	ln      *net.UnixListener
+	mu      sync.Mutex
+	clients map[*net.UnixConn]bool
+	//客户端的输入写到这里，nil就是容器没有-i，只能看
+	//单独一把锁，容器不读输入的时候不能把输出也卡住；多个客户端的输入也不能交错
+	inputMu sync.Mutex
+	input   io.Writer
+	//tty的时候resize用
+	master *os.File
+}
+
+func listenConsole(id string) (*consoleServer, error) {
+	sock := consolePath(id)
+	//上一次的supervisor被kill -9的话会留下来
+	os.Remove(sock)
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: sock, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("fail to listen console,%v", err)
+	}
+	return &consoleServer{ln: ln, clients: map[*net.UnixConn]bool{}}, nil
+}
+
+// waitClient run -t的时候等CLI先连上再启动容器，不然一开始的输出(比如shell的提示符)就看不到了
+func (s *consoleServer) waitClient(timeout time.Duration) {
+	s.ln.SetDeadline(time.Now().Add(timeout))
+	conn, err := s.ln.AcceptUnix()
+	s.ln.SetDeadline(time.Time{})
+	if err != nil {
+		slog.Warn("no client attached", "error", err)
+		return
+	}
+	s.clients[conn] = true
+}
+
+// start input和master要在这之前设好，之后才开始处理客户端发来的东西
+func (s *consoleServer) start() {
+	s.mu.Lock()
+	for conn := range s.clients {
+		go s.handle(conn)
+	}
+	s.mu.Unlock()
+	go s.accept()
+}
+
+// copyConsole tty的容器从master读输出，返回的channel在容器里的进程都退出、输出读完之后关掉
+func (s *consoleServer) copyConsole(master *os.File, log io.Writer) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		io.Copy(io.MultiWriter(log, s.output(frameStdout)), isolation.ConsoleReader(master))
+	}()
+	return done
+}
+
+func (s *consoleServer) accept() {
+	for {
+		conn, err := s.ln.AcceptUnix()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.clients[conn] = true
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+// output 容器的stdout或者stderr，写进来的都广播出去
+func (s *consoleServer) output(typ byte) io.Writer {
+	return consoleWriter{s, typ}
+}
+
+type consoleWriter struct {
+	s   *consoleServer
+	typ byte
+}
+
+func (w consoleWriter) Write(p []byte) (int, error) {
+	w.s.broadcast(w.typ, p)
+	return len(p), nil
+}
+
+func (s *consoleServer) broadcast(typ byte, p []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.clients {
+		//卡住的客户端直接踢掉，不能让它拖着容器
+		conn.SetWriteDeadline(time.Now().Add(time.Second))
+		if err := writeFrame(conn, typ, p); err != nil {
+			conn.Close()
+			delete(s.clients, conn)
+		}
+	}
+}
+
+func (s *consoleServer) handle(conn *net.UnixConn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+	for {
+		typ, payload, err := readFrame(conn)
+		if err != nil {
+			return
+		}
+		switch typ {
+		case frameStdin:
+			if s.input != nil {
+				s.inputMu.Lock()
+				s.input.Write(payload)
+				s.inputMu.Unlock()
+			}
+		case frameResize:
+			if s.master != nil && len(payload) == 4 {
+				size := &isolation.Winsize{
+					Rows: binary.BigEndian.Uint16(payload),
+					Cols: binary.BigEndian.Uint16(payload[2:]),
+				}
+				if err := isolation.Resize(s.master, size); err != nil {
+					slog.Warn("fail to resize console", "error", err)
+				}
+			}
+		}
+	}
+}
+
+// Close 客户端读到EOF就知道容器已经退出了
+func (s *consoleServer) Close() {
+	s.ln.Close()
+	os.Remove(s.ln.Addr().String())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.clients {
+		conn.Close()
+		delete(s.clients, conn)
+	}
+}
+
+// ParseDetachKeys ctrl-p,ctrl-q这种，也可以直接写单个字符
+func ParseDetachKeys(keys string) ([]byte, error) {
+	if keys == "" {
+		keys = DefaultDetachKeys
+	}
+	var seq []byte
+	for _, key := range strings.Split(keys, ",") {
+		if len(key) == 1 {
+			seq = append(seq, key[0])
+			continue
+		}
+		name, ok := strings.CutPrefix(strings.ToLower(key), "ctrl-")
+		if !ok || len(name) != 1 {
+			return nil, fmt.Errorf("invalid detach keys %q", keys)
+		}
+		switch c := name[0]; {
+		case c >= 'a' && c <= 'z':
+			seq = append(seq, c-'a'+1)
+		case c == '@':
+			seq = append(seq, 0)
+		case c >= '[' && c <= '_':
+			//ctrl-[ ctrl-\ ctrl-] ctrl-^ ctrl-_ 是27到31
+			seq = append(seq, c-'['+27)
+		default:
+			return nil, fmt.Errorf("invalid detach keys %q", keys)
+		}
+	}
+	return seq, nil
+}
+
+// detachReader 读到detach键的序列就返回errDetached
+// 序列只对上一半的先攒着，后面对不上再原样放出去
+type detachReader struct {
+	r       io.Reader
+	keys    []byte
+	matched int
+	pending []byte
+}
+
+func (d *detachReader) Read(p []byte) (int, error) {
+	if len(d.pending) > 0 {
+		n := copy(p, d.pending)
+		d.pending = d.pending[n:]
+		return n, nil
+	}
+	buf := make([]byte, len(p))
+	n, err := d.r.Read(buf)
+	var out []byte
+	for _, b := range buf[:n] {
+		if b == d.keys[d.matched] {
+			d.matched++
+			if d.matched == len(d.keys) {
+				return copy(p, out), errDetached
+			}
+			continue
+		}
+		out = append(out, d.keys[:d.matched]...)
+		d.matched = 0
+		if b == d.keys[0] {
+			d.matched = 1
+			continue
+		}
+		out = append(out, b)
+	}
+	written := copy(p, out)
+	d.pending = out[written:]
+	return written, err
+}
+
+// attachConsole 把本地终端接到容器的console上，一直到容器退出或者按了detach键
+// 返回true表示是detach断开的，容器还在跑；stdin是nil就只看输出
+func attachConsole(conn *net.UnixConn, tty bool, stdin *os.File, stdout, stderr io.Writer, detachKeys []byte) (bool, error) {
+	defer conn.Close()
+	var writeMu sync.Mutex
+	send := func(typ byte, payload []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return writeFrame(conn, typ, payload)
+	}
+
+	if tty {
+		//窗口大小从终端拿
+		term := os.Stdout
+		if stdin != nil {
+			term = stdin
+		}
+		if stdin != nil && isolation.IsTerminal(stdin.Fd()) {
+			restore, err := isolation.MakeRaw(stdin.Fd())
+			if err != nil {
+				return false, fmt.Errorf("fail to set raw mode,%v", err)
+			}
+			defer restore()
+		}
+		resize := func() {
+			size, err := isolation.GetWinsize(term.Fd())
+			if err != nil {
+				return
+			}
+			payload := make([]byte, 4)
+			binary.BigEndian.PutUint16(payload, size.Rows)
+			binary.BigEndian.PutUint16(payload[2:], size.Cols)
+			send(frameResize, payload)
+		}
+		resize()
+		winch := make(chan os.Signal, 1)
+		signal.Notify(winch, syscall.SIGWINCH)
+		defer signal.Stop(winch)
+		go func() {
+			for range winch {
+				resize()
+			}
+		}()
+	}
+
+	detached := make(chan struct{})
+	if stdin != nil {
+		go func() {
+			var r io.Reader = stdin
+			if len(detachKeys) > 0 {
+				r = &detachReader{r: stdin, keys: detachKeys}
+			}
+			buf := make([]byte, 32*1024)
+			for {
+				n, err := r.Read(buf)
+				if n > 0 {
+					if send(frameStdin, append([]byte{}, buf[:n]...)) != nil {
+						return
+					}
+				}
+				if err == errDetached {
+					close(detached)
+					return
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	output := make(chan error, 1)
+	go func() {
+		for {
+			typ, payload, err := readFrame(conn)
+			if err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				output <- err
+				return
+			}
+			w := stdout
+			if typ == frameStderr {
+				w = stderr
+			}
+			w.Write(payload)
+		}
+	}()
+	select {
+	case <-detached:
+		return true, nil
+	case err := <-output:
+		return false, err
+	}
+}
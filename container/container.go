@@ -6,9 +6,10 @@ import (
 	"docker/storage"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"os"
 	"path"
-	"strconv"
+	"sort"
 	"strings"
 	"syscall"
 	"time"
@@ -25,145 +26,276 @@ type Container struct {
 	ID         string    `json:"id"`
 	Name       string    `json:"name"`
 	Image      string    `json:"image"`
+	ImageID    string    `json:"image_id"`
 	Command    string    `json:"command"`
 	CreateTime time.Time `json:"create_time"`
-	Status     string    `json:"status"`
-	Pid        int       `json:"pid"`
+	State      State     `json:"state"`
+	ShimPid    int       `json:"shim_pid,omitempty"`
+	Rootfs     string    `json:"rootfs"`
+	Driver     string    `json:"driver"`
+	Process    *Process  `json:"process"`
+	//-i，attach的时候才会把输入交给容器
+	OpenStdin bool      `json:"open_stdin,omitempty"`
+	LogConfig LogConfig `json:"log_config"`
+	//空的就是SIGTERM
+	StopSignal  string `json:"stop_signal,omitempty"`
+	StopTimeout int    `json:"stop_timeout"`
+	//镜像的label打底，命令行的覆盖
+	Labels       map[string]string `json:"labels,omitempty"`
+	ExposedPorts []string          `json:"exposed_ports,omitempty"`
+	//每次启动都按这个建cgroup
+	Resources isolation.Resources `json:"resources"`
+	//update可以改，现在只是存下来
+	RestartPolicy RestartPolicy `json:"restart_policy"`
 }
 
-func makeID() string {
-	return strconv.FormatInt(time.Now().UnixNano(), 10)
+// RunOptions run命令的参数
+type RunOptions struct {
+	Name        string
+	Image       string
+	Platform    string
+	Command     []string
+	Interactive bool
+	Tty         bool
+	Detach      bool
+	DetachKeys  string
+	LogConfig   LogConfig
+	StopSignal  string
+	//小于0用默认的10秒
+	StopTimeout int
+	Labels      map[string]string
+	Resources   isolation.Resources
 }
 
-func NewContainer(name, image, command string, pid int) *Container {
+// NewContainer pid要等init进程真正起来之后由supervise写进去
+func NewContainer(name, image, command string) *Container {
 	return &Container{
 		ID:         makeID(),
 		Name:       name,
 		Image:      image,
 		Command:    command,
 		CreateTime: time.Now(),
-		Status:     "running",
-		Pid:        pid,
+		State:      State{Status: StatusCreated},
 	}
 }
 
+// saveContainerInfo 先写临时文件再rename，shim和CLI同时读写也不会读到半个文件
 func saveContainerInfo(container *Container) error {
 	infoPath := path.Join(ContainerRoot, container.ID, "info")
-	file, err := os.Create(infoPath)
+	data, err := json.MarshalIndent(container, "", "	")
 	if err != nil {
+		return fmt.Errorf("fail to marshal container,%v", err)
+	}
+	tmp := infoPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
 		return fmt.Errorf("fail to create container,%v", err)
 	}
-	defer file.Close()
+	return os.Rename(tmp, infoPath)
+}
 
-	data, err := json.MarshalIndent(container, "", "	")
-	if err != nil {
-		return fmt.Errorf("fail to marshal container,%v", err)
+func (c *Container) initConfig() *isolation.ContainerConfig {
+	resources := c.Resources
+	return &isolation.ContainerConfig{
+		ID:        c.ID,
+		Name:      c.Name,
+		Image:     c.Image,
+		Command:   c.Command,
+		Rootfs:    c.Rootfs,
+		Args:      c.Process.Argv(),
+		Env:       c.Process.Env,
+		Dir:       c.Process.Dir,
+		User:      c.Process.User,
+		Tty:       c.Process.Tty,
+		Resources: &resources,
 	}
-	_, err = file.Write(data)
-	return err
 }
 
-func ListContainers() error {
-	entries, err := os.ReadDir(ContainerRoot)
+// Stop 先发stop signal，timeout秒之后还没退出就SIGKILL，timeout小于0用容器自己的设置
+func Stop(ID string, timeout int, signal string) error {
+	c, err := Lookup(ID)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return fmt.Errorf("did not exist,%v", err)
-		}
 		return err
 	}
+	//和docker一样，已经停了的直接返回
+	if !c.State.Running() {
+		return nil
+	}
+	sig, err := c.stopSignal(signal)
+	if err != nil {
+		return err
+	}
+	if timeout < 0 {
+		timeout = c.StopTimeout
+	}
 
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			continue
-		}
-		infoPath := path.Join(ContainerRoot, entry.Name(), "info")
-		data, err := os.ReadFile(infoPath)
-		if err != nil {
-			continue
-		}
+	if err := c.signal(sig, true); err != nil {
+		return err
+	}
+	if waitStopped(c.ID, time.Duration(timeout)*time.Second) {
+		return nil
+	}
 
-		var container Container
-		if err := json.Unmarshal(data, &container); err != nil {
-			continue
-		}
-		fmt.Printf("%s  %s  %s  %s\n", container.ID, container.Name, container.Image, container.Status)
+	slog.Info("container did not stop in time, killing", "id", c.ID, "timeout", timeout)
+	if err := syscall.Kill(c.State.Pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		return fmt.Errorf("fail to kill container,%v", err)
+	}
+	if !waitStopped(c.ID, 10*time.Second) {
+		return fmt.Errorf("container %s did not exit after SIGKILL", c.ID)
 	}
 	return nil
 }
 
-func Stop(ID string) error {
-	infoPath := path.Join(ContainerRoot, ID, "info")
-	data, err := os.ReadFile(infoPath)
+// prepareRootfs 镜像层交给存储驱动，拿到挂载好的rootfs
+func prepareRootfs(id, imageID string) (string, storage.StorageDriver, error) {
+	driver, err := storage.GetDriver("")
+	if err != nil {
+		return "", nil, err
+	}
+	layers, err := image.Layers(imageID)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := driver.Create(id, layers); err != nil {
+		driver.Remove(id)
+		return "", nil, err
+	}
+	rootfs, err := driver.Mount(id)
 	if err != nil {
-		return fmt.Errorf("fail to read file,%v", err)
+		driver.Remove(id)
+		return "", nil, err
 	}
+	return rootfs, driver, nil
+}
 
-	var info Container
-	if err := json.Unmarshal(data, &info); err != nil {
-		return fmt.Errorf("fail to unmarshal container,%v", err)
+// Create 准备好rootfs和进程配置，不启动
+func Create(opts *RunOptions) (*Container, error) {
+	if err := opts.Resources.Validate(); err != nil {
+		return nil, err
+	}
+	container := NewContainer(opts.Name, opts.Image, strings.Join(opts.Command, " "))
+	container.LogConfig = opts.LogConfig
+	container.StopTimeout = opts.StopTimeout
+	if container.StopTimeout < 0 {
+		container.StopTimeout = DefaultStopTimeout
+	}
+	name, err := reserveName(opts.Name, container.ID)
+	if err != nil {
+		return nil, err
+	}
+	container.Name = name
+	containerDir := path.Join(ContainerRoot, container.ID)
+	defer func() {
+		if err != nil {
+			releaseName(container.Name, container.ID)
+			os.RemoveAll(containerDir)
+		}
+	}()
+	if err = os.MkdirAll(containerDir, 0755); err != nil {
+		return nil, fmt.Errorf("fail to create dir,%v", err)
 	}
 
-	process, err := os.FindProcess(info.Pid)
+	imageID, err := image.Check(opts.Image, opts.Platform)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("image error,%v", err)
+	}
+	rootfs, driver, err := prepareRootfs(container.ID, imageID)
+	if err != nil {
+		return nil, fmt.Errorf("rootfs error,%v", err)
+	}
+	defer func() {
+		if err != nil {
+			driver.Remove(container.ID)
+		}
+	}()
+	config, err := image.GetImageConfig(imageID)
+	if err != nil {
+		return nil, fmt.Errorf("fail to get image config,%v", err)
+	}
+	process, err := newProcessFromImage(&config.Config, opts.Command, opts.Tty)
+	if err != nil {
+		return nil, err
 	}
-	if err := process.Signal(os.Interrupt); err != nil {
-		return fmt.Errorf("fail to signal,%v", err)
-	}
-
-	timeout := time.After(10 * time.Second)
-	ticker := time.Tick(100 * time.Millisecond)
-
-	for {
-		select {
-		case <-timeout:
-			if err := process.Kill(); err != nil {
-				return fmt.Errorf("fail to kill container,%v", err)
-			}
-			return nil
-		case <-ticker:
-			if err := process.Signal(syscall.Signal(0)); err != nil {
-				info.Status = "stopped"
-				return saveContainerInfo(&info)
-			}
+	container.Labels = map[string]string{}
+	for k, v := range config.Config.Labels {
+		container.Labels[k] = v
+	}
+	for k, v := range opts.Labels {
+		container.Labels[k] = v
+	}
+	for port := range config.Config.ExposedPorts {
+		container.ExposedPorts = append(container.ExposedPorts, port)
+	}
+	sort.Strings(container.ExposedPorts)
+	container.StopSignal = opts.StopSignal
+	if container.StopSignal == "" {
+		container.StopSignal = config.Config.StopSignal
+	}
+	if container.StopSignal != "" {
+		if _, err = ParseSignal(container.StopSignal); err != nil {
+			return nil, err
 		}
+	}
+	container.ImageID = imageID
+	container.Rootfs = rootfs
+	container.Driver = driver.Name()
+	container.Process = process
+	container.OpenStdin = opts.Interactive
+	container.Resources = opts.Resources
+	container.Command = strings.Join(process.Argv(), " ")
 
+	if err = saveContainerInfo(container); err != nil {
+		return nil, err
 	}
+	return container, nil
 }
 
-func Exec(ID string, cmd []string) error {
-	infoPath := path.Join(ContainerRoot, ID, "info")
-	data, err := os.ReadFile(infoPath)
+// Run 前台运行的时候CLI自己守着容器，返回容器的退出码
+// detach的话交给shim，容器起来就返回；启动失败的容器和docker一样留着，可以rm
+// 带tty的前台run也交给shim，CLI只是连到console上，按detach键断开之后容器接着跑
+func Run(opts *RunOptions) (*Container, int, error) {
+	detachKeys, err := ParseDetachKeys(opts.DetachKeys)
 	if err != nil {
-		return fmt.Errorf("fail to read file,%v", err)
+		return nil, -1, err
 	}
-	var info Container
-	if err := json.Unmarshal(data, &info); err != nil {
-		return fmt.Errorf("fail to unmarshal container,%v", err)
+	if opts.Tty && opts.Interactive && !opts.Detach && !isolation.IsTerminal(os.Stdin.Fd()) {
+		return nil, -1, fmt.Errorf("the input device is not a TTY")
+	}
+	container, err := Create(opts)
+	if err != nil {
+		return nil, -1, err
 	}
-	rootfs := path.Join(storage.ImageRoot, info.Image, "rootfs")
-	return isolation.ExecInContainer(info.ID, rootfs, cmd)
-}
 
-func Run(name, images string, command []string, interactive bool) (int, error) {
-	container := NewContainer(name, images, strings.Join(command, " "), os.Getpid())
-	containerDir := path.Join(ContainerRoot, container.ID)
-	var err error
-	if err := os.MkdirAll(containerDir, 0755); err != nil {
-		return container.Pid, fmt.Errorf("fail to create dir,%v", err)
+	if opts.Detach {
+		return container, 0, startShim(container.ID)
 	}
-	defer func() {
+
+	if opts.Tty {
+		conn, err := startShimAttached(container.ID)
 		if err != nil {
-			os.RemoveAll(containerDir)
+			return container, -1, err
 		}
-	}()
+		var stdin *os.File
+		if opts.Interactive {
+			stdin = os.Stdin
+		}
+		detached, err := attachConsole(conn, true, stdin, os.Stdout, os.Stderr, detachKeys)
+		if err != nil || detached {
+			return container, 0, err
+		}
+		code, err := attachedExit(container.ID)
+		return container, code, err
+	}
 
-	rootfs, err := image.Check(images, container.ID)
+	//前台run也能被attach
+	console, err := listenConsole(container.ID)
 	if err != nil {
-		return container.Pid, fmt.Errorf("rootfs error,%v", err)
+		return container, -1, err
 	}
-	if err := saveContainerInfo(container); err != nil {
-		return container.Pid, err
+	defer console.Close()
+	stdio := &isolation.Stdio{Stdout: os.Stdout, Stderr: os.Stderr}
+	if opts.Interactive {
+		stdio.Stdin = os.Stdin
 	}
-	return isolation.StartContainer(container.ID, rootfs, command, interactive)
+	code, err := supervise(container, stdio, console, func(error) {})
+	return container, code, err
 }
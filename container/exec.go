@@ -0,0 +1,170 @@
+package container
+
+import (
+	"docker/isolation"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// ExecOptions exec命令的参数，空的就用容器自己的配置
+type ExecOptions struct {
+	Cmd         []string
+	Env         []string
+	User        string
+	Workdir     string
+	Interactive bool
+	Tty         bool
+	Detach      bool
+	DetachKeys  string
+}
+
+// Exec 在运行中的容器里起一个进程，返回它的退出码；detach的话起来就返回
+func Exec(ID string, opts *ExecOptions) (int, error) {
+	c, err := Lookup(ID)
+	if err != nil {
+		return -1, err
+	}
+	if c.State.Status == StatusPaused {
+		return -1, fmt.Errorf("container %s is paused, unpause the container before exec", ID)
+	}
+	if !c.State.Running() {
+		return -1, fmt.Errorf("container %s is not running", ID)
+	}
+	if len(opts.Cmd) == 0 {
+		return -1, fmt.Errorf("empty command")
+	}
+	detachKeys, err := ParseDetachKeys(opts.DetachKeys)
+	if err != nil {
+		return -1, err
+	}
+	if opts.Tty && opts.Interactive && !opts.Detach && !isolation.IsTerminal(os.Stdin.Fd()) {
+		return -1, fmt.Errorf("the input device is not a TTY")
+	}
+
+	config := &isolation.ExecConfig{
+		ID:   c.ID,
+		Pid:  c.State.Pid,
+		Args: opts.Cmd,
+		Env:  c.Process.Env,
+		Dir:  c.Process.Dir,
+		User: c.Process.User,
+		Tty:  opts.Tty && !opts.Detach,
+	}
+	config.Env = mergeEnv(config.Env, execEnv(opts.Env))
+	if opts.Tty {
+		config.Env = mergeEnv(config.Env, []string{"TERM=xterm"})
+	}
+	if opts.User != "" {
+		config.User = opts.User
+	}
+	if opts.Workdir != "" {
+		config.Dir = opts.Workdir
+	}
+
+	stdio := &isolation.Stdio{}
+	if !opts.Detach && !opts.Tty {
+		stdio.Stdout, stdio.Stderr = os.Stdout, os.Stderr
+		if opts.Interactive {
+			stdio.Stdin = os.Stdin
+		}
+	}
+	proc, master, err := isolation.ExecInContainer(config, stdio)
+	if err != nil {
+		return -1, err
+	}
+	//CLI退出之后由宿主机的init回收
+	if opts.Detach {
+		return 0, proc.Process.Release()
+	}
+	if master == nil {
+		return exitCode(proc.Wait()), nil
+	}
+
+	defer master.Close()
+	if opts.Interactive && isolation.IsTerminal(os.Stdin.Fd()) {
+		restore, err := isolation.MakeRaw(os.Stdin.Fd())
+		if err != nil {
+			proc.Process.Kill()
+			proc.Wait()
+			return -1, fmt.Errorf("fail to set raw mode,%v", err)
+		}
+		defer restore()
+	}
+	term := os.Stdout
+	if opts.Interactive {
+		term = os.Stdin
+	}
+	resize := func() {
+		if size, err := isolation.GetWinsize(term.Fd()); err == nil {
+			isolation.Resize(master, size)
+		}
+	}
+	resize()
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+	go func() {
+		for range winch {
+			resize()
+		}
+	}()
+
+	//和attach一样，按了detach键就不管这个进程了，终端由上面的defer恢复
+	detached := make(chan struct{})
+	if opts.Interactive {
+		go func() {
+			r := &detachReader{r: os.Stdin, keys: detachKeys}
+			buf := make([]byte, 32*1024)
+			for {
+				n, err := r.Read(buf)
+				if n > 0 {
+					if _, werr := master.Write(buf[:n]); werr != nil {
+						return
+					}
+				}
+				if err == errDetached {
+					close(detached)
+					return
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+	output := make(chan struct{})
+	go func() {
+		io.Copy(os.Stdout, isolation.ConsoleReader(master))
+		close(output)
+	}()
+	exited := make(chan int, 1)
+	go func() {
+		exited <- exitCode(proc.Wait())
+	}()
+	select {
+	case <-detached:
+		return 0, nil
+	case code := <-exited:
+		<-output
+		return code, nil
+	}
+}
+
+// execEnv 和docker一样，-e FOO不带值的话取宿主机上的，宿主机也没有就不设
+func execEnv(env []string) []string {
+	var out []string
+	for _, kv := range env {
+		if strings.Contains(kv, "=") {
+			out = append(out, kv)
+			continue
+		}
+		if v, ok := os.LookupEnv(kv); ok {
+			out = append(out, kv+"="+v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,103 @@
+package container
+
+import (
+	"docker/isolation"
+	"docker/network"
+	"time"
+)
+
+// ContainerInspect inspect命令输出的容器信息
+type ContainerInspect struct {
+	ID              string            `json:"id"`
+	Name            string            `json:"name"`
+	Created         time.Time         `json:"created"`
+	Path            string            `json:"path"`
+	Args            []string          `json:"args"`
+	State           State             `json:"state"`
+	Image           string            `json:"image"`
+	Config          InspectConfig     `json:"config"`
+	HostConfig      HostConfig        `json:"host_config"`
+	Driver          string            `json:"driver"`
+	Mounts          []Mount           `json:"mounts"`
+	NetworkSettings *network.Endpoint `json:"network_settings"`
+	CgroupPath      string            `json:"cgroup_path"`
+	LogPath         string            `json:"log_path"`
+	LogConfig       LogConfig         `json:"log_config"`
+}
+
+type InspectConfig struct {
+	Image       string   `json:"image"`
+	Cmd         []string `json:"cmd"`
+	Env         []string `json:"env"`
+	WorkingDir  string   `json:"working_dir"`
+	User        string   `json:"user"`
+	StopSignal  string   `json:"stop_signal"`
+	StopTimeout int      `json:"stop_timeout"`
+}
+
+// HostConfig 资源限制和重启策略，--cpus是按quota和period算出来的
+type HostConfig struct {
+	isolation.Resources
+	CPUs          float64       `json:"cpus"`
+	RestartPolicy RestartPolicy `json:"restart_policy"`
+}
+
+type Mount struct {
+	Type        string `json:"type"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+}
+
+func Inspect(ref string) (*ContainerInspect, error) {
+	c, err := Lookup(ref)
+	if err != nil {
+		return nil, err
+	}
+	info := &ContainerInspect{
+		ID:         c.ID,
+		Name:       c.Name,
+		Created:    c.CreateTime,
+		State:      c.State,
+		Image:      c.ImageID,
+		Driver:     c.Driver,
+		Mounts:     c.mounts(),
+		CgroupPath: isolation.NewCgroupManager(c.ID).Path(),
+		LogPath:    logPath(c.ID),
+		LogConfig:  c.LogConfig,
+		Config: InspectConfig{
+			Image:       c.Image,
+			StopSignal:  c.StopSignal,
+			StopTimeout: c.StopTimeout,
+		},
+		HostConfig: HostConfig{
+			Resources:     c.Resources,
+			CPUs:          c.Resources.CPUs(),
+			RestartPolicy: c.RestartPolicy,
+		},
+	}
+	if p := c.Process; p != nil {
+		info.Path = p.Command
+		info.Args = p.Args
+		info.Config.Cmd = p.Argv()
+		info.Config.Env = p.Env
+		info.Config.WorkingDir = p.Dir
+		info.Config.User = p.User
+	}
+	//停掉的容器netns已经没了，和docker一样不显示
+	if c.State.Running() {
+		if ep, err := network.GetEndpoint(c.ID, c.State.Pid); err == nil {
+			info.NetworkSettings = ep
+		}
+	}
+	return info, nil
+}
+
+// mounts 容器里除了rootfs还有init进程挂的proc、tmpfs和devpts
+func (c *Container) mounts() []Mount {
+	return []Mount{
+		{Type: c.Driver, Source: c.Rootfs, Destination: "/"},
+		{Type: "proc", Source: "proc", Destination: "/proc"},
+		{Type: "tmpfs", Source: "tmpfs", Destination: "/tmpfs"},
+		{Type: "devpts", Source: "devpts", Destination: "/dev/pts"},
+	}
+}
@@ -0,0 +1,96 @@
+package container
+
+import (
+	"docker/isolation"
+	"docker/storage"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"syscall"
+	"time"
+)
+
+// Start 重新挂好rootfs交给shim启动，created和已经停掉的容器都可以
+func Start(ID string) error {
+	c, err := Lookup(ID)
+	if err != nil {
+		return err
+	}
+	//和docker一样，已经在跑的直接返回
+	if isRunning(c.ID) {
+		return nil
+	}
+	driver, err := storage.GetDriver(c.Driver)
+	if err != nil {
+		return err
+	}
+	rootfs, err := driver.Mount(c.ID)
+	if err != nil {
+		return fmt.Errorf("rootfs error,%v", err)
+	}
+	if rootfs != c.Rootfs {
+		err := updateContainer(c.ID, func(info *Container) error {
+			info.Rootfs = rootfs
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return startShim(c.ID)
+}
+
+func Restart(ID string, timeout int) error {
+	if err := Stop(ID, timeout, ""); err != nil {
+		return err
+	}
+	return Start(ID)
+}
+
+// Remove 删掉容器的rootfs、cgroup、网络残留和记录，在跑的要force才删
+// 现在还没有匿名卷，volumes先留着和docker的参数对上
+func Remove(ID string, force, volumes bool) error {
+	c, err := Lookup(ID)
+	if err != nil {
+		return err
+	}
+	if isRunning(c.ID) {
+		if !force {
+			return fmt.Errorf("cannot remove running container %s, stop it first or use -f", c.ID)
+		}
+		if err := kill(c); err != nil {
+			return err
+		}
+	}
+
+	driver, err := storage.GetDriver(c.Driver)
+	if err != nil {
+		return err
+	}
+	if err := driver.Remove(c.ID); err != nil {
+		return fmt.Errorf("fail to remove rootfs,%v", err)
+	}
+	if err := isolation.NewCgroupManager(c.ID).Remove(); err != nil {
+		slog.Warn("fail to remove cgroup", "id", c.ID, "error", err)
+	}
+	if err := isolation.CleanNameSpace(c.ID); err != nil {
+		slog.Warn("fail to clean network", "id", c.ID, "error", err)
+	}
+	if err := os.RemoveAll(path.Join(ContainerRoot, c.ID)); err != nil {
+		return err
+	}
+	releaseName(c.Name, c.ID)
+	return nil
+}
+
+// kill 直接SIGKILL，等supervise记下退出状态
+func kill(c *Container) error {
+	if err := c.signal(syscall.SIGKILL, true); err != nil {
+		return err
+	}
+	if !waitStopped(c.ID, 10*time.Second) {
+		return fmt.Errorf("container %s did not exit", c.ID)
+	}
+	return nil
+}
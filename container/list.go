@@ -0,0 +1,220 @@
+package container
+
+import (
+	"docker/image"
+	"docker/storage"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ListOptions ps的参数，同一个key的多个filter是或，不同key之间是且
+type ListOptions struct {
+	All     bool
+	Filters map[string][]string
+}
+
+var filterKeys = map[string]bool{
+	"status": true, "name": true, "label": true, "ancestor": true, "before": true, "since": true,
+}
+
+// List 按创建时间从新到旧，默认只列出在跑的(包括paused)
+func List(opts ListOptions) ([]*Container, error) {
+	for key, values := range opts.Filters {
+		if !filterKeys[key] {
+			return nil, fmt.Errorf("invalid filter %q", key)
+		}
+		if key == "status" {
+			for _, v := range values {
+				if _, ok := transitions[Status(v)]; !ok {
+					return nil, fmt.Errorf("invalid filter 'status=%s'", v)
+				}
+			}
+		}
+	}
+	match, err := newFilter(opts.Filters)
+	if err != nil {
+		return nil, err
+	}
+
+	containers, err := ListAll()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].CreateTime.After(containers[j].CreateTime)
+	})
+
+	//指定了status就不用-a也能看到停掉的
+	all := opts.All || len(opts.Filters["status"]) > 0
+	var list []*Container
+	for _, c := range containers {
+		if !all && !c.State.Running() {
+			continue
+		}
+		if match(c) {
+			list = append(list, c)
+		}
+	}
+	return list, nil
+}
+
+func newFilter(filters map[string][]string) (func(*Container) bool, error) {
+	var checks []func(*Container) bool
+
+	if values := filters["status"]; len(values) > 0 {
+		checks = append(checks, func(c *Container) bool {
+			return contains(values, string(c.State.Status))
+		})
+	}
+	if values := filters["name"]; len(values) > 0 {
+		var patterns []*regexp.Regexp
+		for _, v := range values {
+			re, err := regexp.Compile(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid name filter %q,%v", v, err)
+			}
+			patterns = append(patterns, re)
+		}
+		checks = append(checks, func(c *Container) bool {
+			for _, re := range patterns {
+				if re.MatchString(c.Name) {
+					return true
+				}
+			}
+			return false
+		})
+	}
+	//label=key或者label=key=value，多个label要全部满足，和docker一样
+	for _, v := range filters["label"] {
+		key, value, hasValue := strings.Cut(v, "=")
+		checks = append(checks, func(c *Container) bool {
+			got, ok := c.Labels[key]
+			return ok && (!hasValue || got == value)
+		})
+	}
+	if values := filters["ancestor"]; len(values) > 0 {
+		var ids []string
+		for _, v := range values {
+			if id, err := image.Resolve(v); err == nil {
+				ids = append(ids, id)
+			}
+		}
+		checks = append(checks, func(c *Container) bool {
+			return contains(ids, c.ImageID) || contains(values, c.Image)
+		})
+	}
+	for _, key := range []string{"before", "since"} {
+		for _, v := range filters[key] {
+			ref, err := Lookup(v)
+			if err != nil {
+				return nil, err
+			}
+			created, before := ref.CreateTime, key == "before"
+			checks = append(checks, func(c *Container) bool {
+				if before {
+					return c.CreateTime.Before(created)
+				}
+				return c.CreateTime.After(created)
+			})
+		}
+	}
+
+	return func(c *Container) bool {
+		for _, check := range checks {
+			if !check(c) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Summary ps里一行的内容，--format的模板里用的就是这些字段
+type Summary struct {
+	ID         string            `json:"ID"`
+	Image      string            `json:"Image"`
+	Command    string            `json:"Command"`
+	CreatedAt  string            `json:"CreatedAt"`
+	RunningFor string            `json:"RunningFor"`
+	Ports      string            `json:"Ports"`
+	State      string            `json:"State"`
+	Status     string            `json:"Status"`
+	Names      string            `json:"Names"`
+	Labels     string            `json:"Labels"`
+	LabelMap   map[string]string `json:"-"`
+}
+
+// Label 模板里用{{.Label "key"}}
+func (s *Summary) Label(key string) string {
+	return s.LabelMap[key]
+}
+
+func NewSummary(c *Container, noTrunc bool) *Summary {
+	now := time.Now()
+	s := &Summary{
+		ID:         c.ID,
+		Image:      c.Image,
+		Command:    c.Command,
+		CreatedAt:  c.CreateTime.Format("2006-01-02 15:04:05 -0700 MST"),
+		RunningFor: storage.HumanDuration(now.Sub(c.CreateTime)) + " ago",
+		Ports:      strings.Join(c.ExposedPorts, ", "),
+		State:      string(c.State.Status),
+		Status:     c.statusString(now),
+		Names:      c.Name,
+		LabelMap:   c.Labels,
+	}
+	var labels []string
+	for k, v := range c.Labels {
+		labels = append(labels, k+"="+v)
+	}
+	sort.Strings(labels)
+	s.Labels = strings.Join(labels, ",")
+
+	if !noTrunc {
+		s.ID = ShortID(c.ID)
+		if len([]rune(s.Command)) > 20 {
+			s.Command = string([]rune(s.Command)[:19]) + "…"
+		}
+	}
+	s.Command = fmt.Sprintf("%q", s.Command)
+	return s
+}
+
+// statusString Up 5 minutes、Exited (0) 3 hours ago这种
+func (c *Container) statusString(now time.Time) string {
+	st := c.State
+	switch st.Status {
+	case StatusRunning:
+		return "Up " + storage.HumanDuration(now.Sub(st.StartedAt))
+	case StatusPaused:
+		return "Up " + storage.HumanDuration(now.Sub(st.StartedAt)) + " (Paused)"
+	case StatusExited:
+		if st.FinishedAt.IsZero() {
+			return fmt.Sprintf("Exited (%d)", st.ExitCode)
+		}
+		return fmt.Sprintf("Exited (%d) %s ago", st.ExitCode, storage.HumanDuration(now.Sub(st.FinishedAt)))
+	case StatusDead:
+		return "Dead"
+	}
+	return "Created"
+}
+
+// ShortID 显示用的前12位
+func ShortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
@@ -0,0 +1,311 @@
+package container
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+// LogConfig 对应docker的--log-opt max-size和max-file
+type LogConfig struct {
+	MaxSize int64 `json:"max_size"`
+	MaxFile int   `json:"max_file"`
+}
+
+// 默认每个容器最多留3个10M的日志文件
+var DefaultLogConfig = LogConfig{MaxSize: 10 << 20, MaxFile: 3}
+
+// logEntry 和docker的json-file驱动格式一样，一行一个
+type logEntry struct {
+	Log    string    `json:"log"`
+	Stream string    `json:"stream"`
+	Time   time.Time `json:"time"`
+}
+
+func logPath(id string) string {
+	return path.Join(ContainerRoot, id, ContainerLog)
+}
+
+// jsonLogger stdout和stderr两个流共用一个文件，写的时候要加锁
+type jsonLogger struct {
+	mu     sync.Mutex
+	path   string
+	config LogConfig
+	file   *os.File
+	size   int64
+}
+
+func openLogger(id string, config LogConfig) (*jsonLogger, error) {
+	l := &jsonLogger{path: logPath(id), config: config}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *jsonLogger) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("fail to open log,%v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file = f
+	l.size = info.Size()
+	return nil
+}
+
+func (l *jsonLogger) write(stream string, line []byte) error {
+	data, err := json.Marshal(&logEntry{Log: string(line), Stream: stream, Time: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.config.MaxSize > 0 && l.size > 0 && l.size+int64(len(data)) > l.config.MaxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.file.Write(data)
+	l.size += int64(n)
+	return err
+}
+
+// rotate container.log -> container.log.1 -> ... 超过max-file的直接丢掉
+func (l *jsonLogger) rotate() error {
+	l.file.Close()
+	if l.config.MaxFile > 1 {
+		for i := l.config.MaxFile - 1; i > 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", l.path, i-1), fmt.Sprintf("%s.%d", l.path, i))
+		}
+		if err := os.Rename(l.path, l.path+".1"); err != nil {
+			return fmt.Errorf("fail to rotate log,%v", err)
+		}
+	} else if err := os.Remove(l.path); err != nil {
+		return fmt.Errorf("fail to rotate log,%v", err)
+	}
+	return l.open()
+}
+
+func (l *jsonLogger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+func (l *jsonLogger) stream(name string) *logStream {
+	return &logStream{logger: l, name: name}
+}
+
+// logStream 按行切开再写，最后没换行的那一段等Close的时候再写
+type logStream struct {
+	logger *jsonLogger
+	name   string
+	buf    []byte
+}
+
+func (s *logStream) Write(p []byte) (int, error) {
+	s.buf = append(s.buf, p...)
+	for {
+		i := bytes.IndexByte(s.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if err := s.logger.write(s.name, s.buf[:i+1]); err != nil {
+			return 0, err
+		}
+		s.buf = s.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (s *logStream) Close() error {
+	if len(s.buf) == 0 {
+		return nil
+	}
+	err := s.logger.write(s.name, s.buf)
+	s.buf = nil
+	return err
+}
+
+type LogsOptions struct {
+	Follow bool
+	//小于0就是全部
+	Tail       int
+	Since      time.Time
+	Until      time.Time
+	Timestamps bool
+}
+
+type logPrinter struct {
+	opts   *LogsOptions
+	stdout io.Writer
+	stderr io.Writer
+	//tail的时候先攒着最后N条
+	tail []*logEntry
+}
+
+func (p *logPrinter) add(e *logEntry) {
+	if !p.opts.Since.IsZero() && e.Time.Before(p.opts.Since) {
+		return
+	}
+	if !p.opts.Until.IsZero() && e.Time.After(p.opts.Until) {
+		return
+	}
+	if p.opts.Tail < 0 {
+		p.print(e)
+		return
+	}
+	if p.opts.Tail == 0 {
+		return
+	}
+	if len(p.tail) == p.opts.Tail {
+		p.tail = p.tail[1:]
+	}
+	p.tail = append(p.tail, e)
+}
+
+func (p *logPrinter) flush() {
+	for _, e := range p.tail {
+		p.print(e)
+	}
+	p.tail = nil
+	//tail只管历史日志，follow出来的新日志都要打
+	p.opts.Tail = -1
+}
+
+func (p *logPrinter) print(e *logEntry) {
+	w := p.stdout
+	if e.Stream == "stderr" {
+		w = p.stderr
+	}
+	if p.opts.Timestamps {
+		fmt.Fprintf(w, "%s %s", e.Time.Format(time.RFC3339Nano), e.Log)
+		return
+	}
+	io.WriteString(w, e.Log)
+}
+
+// readLines 读到EOF为止，最后不完整的一行留在pending里等下次
+func (p *logPrinter) readLines(r *bufio.Reader, pending []byte) ([]byte, error) {
+	for {
+		line, err := r.ReadBytes('\n')
+		pending = append(pending, line...)
+		if err == io.EOF {
+			return pending, nil
+		}
+		if err != nil {
+			return pending, err
+		}
+		var e logEntry
+		if err := json.Unmarshal(pending, &e); err == nil {
+			p.add(&e)
+		}
+		pending = pending[:0]
+	}
+}
+
+// Logs 先按从旧到新读轮转出去的文件，再读当前的，follow的话一直跟到容器退出
+func Logs(id string, opts LogsOptions, stdout, stderr io.Writer) error {
+	c, err := Lookup(id)
+	if err != nil {
+		return err
+	}
+	p := &logPrinter{opts: &opts, stdout: stdout, stderr: stderr}
+	current := logPath(c.ID)
+
+	for i := c.LogConfig.MaxFile - 1; i > 0; i-- {
+		f, err := os.Open(fmt.Sprintf("%s.%d", current, i))
+		if err != nil {
+			continue
+		}
+		_, err = p.readLines(bufio.NewReader(f), nil)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Open(current)
+	if err != nil {
+		if os.IsNotExist(err) {
+			p.flush()
+			return nil
+		}
+		return err
+	}
+	defer func() { f.Close() }()
+	r := bufio.NewReader(f)
+	pending, err := p.readLines(r, nil)
+	if err != nil {
+		return err
+	}
+	p.flush()
+	if !opts.Follow {
+		return nil
+	}
+
+	for {
+		//shim先把日志写完再改状态，所以看到容器停了之后再读一次就是全部了
+		running := isRunning(c.ID)
+		if pending, err = p.readLines(r, pending); err != nil {
+			return err
+		}
+		if !running {
+			return nil
+		}
+		if !opts.Until.IsZero() && time.Now().After(opts.Until) {
+			return nil
+		}
+		if rotated(f, current) {
+			//旧文件在轮转之前可能还写进去了一点
+			if pending, err = p.readLines(r, pending); err != nil {
+				return err
+			}
+			next, err := os.Open(current)
+			if err != nil {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			f.Close()
+			f = next
+			r.Reset(f)
+			pending = pending[:0]
+			continue
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// rotated 当前路径上已经换成另一个文件了
+func rotated(f *os.File, name string) bool {
+	opened, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		return false
+	}
+	return !os.SameFile(opened, info)
+}
+
+func isRunning(id string) bool {
+	c, err := GetContainer(id)
+	if err != nil {
+		return false
+	}
+	return c.State.Running()
+}
@@ -1,12 +1,34 @@
 package container
 
 import (
+	"docker/image"
+	"docker/storage"
 	"encoding/json"
+	"fmt"
 	"os"
 	"path"
+	"strings"
 )
 
+// GetContainer 读的时候顺便核对一下进程还在不在，不会把死掉的容器报成running
 func GetContainer(ID string) (*Container, error) {
+	info, err := loadContainer(ID)
+	if err != nil {
+		return nil, err
+	}
+	if !info.reconcile() {
+		return info, nil
+	}
+	//加锁之后再核对一次，supervisor可能刚好写完
+	err = updateContainer(ID, func(latest *Container) error {
+		latest.reconcile()
+		info = latest
+		return nil
+	})
+	return info, err
+}
+
+func loadContainer(ID string) (*Container, error) {
 	infoPath := path.Join(ContainerRoot, ID, "info")
 
 	data, err := os.ReadFile(infoPath)
@@ -21,11 +43,107 @@ func GetContainer(ID string) (*Container, error) {
 	return &info, nil
 }
 
-func UpdateContainer(ID, status string) error {
+// checkTransition 只检查能不能变到这个状态，不改记录
+func checkTransition(ID string, to Status) error {
 	info, err := GetContainer(ID)
 	if err != nil {
 		return err
 	}
-	info.Status = status
+	state := info.State
+	return state.transition(to)
+}
+
+// updateContainer 加锁读改写，shim和CLI可能同时在改同一个容器
+func updateContainer(ID string, fn func(info *Container) error) error {
+	lock, err := storage.Lock(path.Join(ContainerRoot, ID, "info.lock"))
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	info, err := loadContainer(ID)
+	if err != nil {
+		return err
+	}
+	if err := fn(info); err != nil {
+		return err
+	}
 	return saveContainerInfo(info)
 }
+
+// Lookup 和docker一样，先按完整ID，再按名字，最后按唯一的ID前缀找
+func Lookup(ref string) (*Container, error) {
+	ref = strings.TrimPrefix(ref, "/")
+	if ref == "" || strings.Contains(ref, "/") {
+		return nil, fmt.Errorf("no such container: %s", ref)
+	}
+	if c, err := GetContainer(ref); err == nil {
+		return c, nil
+	}
+	if id, err := os.ReadFile(path.Join(NameRoot, ref)); err == nil {
+		if c, err := GetContainer(string(id)); err == nil && c.Name == ref {
+			return c, nil
+		}
+	}
+
+	entries, err := os.ReadDir(ContainerRoot)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var matches []string
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), ref) {
+			matches = append(matches, entry.Name())
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no such container: %s", ref)
+	case 1:
+		return GetContainer(matches[0])
+	}
+	return nil, fmt.Errorf("multiple containers found with provided prefix: %s", ref)
+}
+
+// ListAll 读出所有容器的记录，读不了的跳过
+func ListAll() ([]*Container, error) {
+	entries, err := os.ReadDir(ContainerRoot)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var containers []*Container
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		info, err := GetContainer(entry.Name())
+		if err != nil {
+			continue
+		}
+		containers = append(containers, info)
+	}
+	return containers, nil
+}
+
+// UsingImage 找出用了这个镜像的容器，tag不同但内容一样的也算
+func UsingImage(name string) ([]string, error) {
+	id, err := image.Resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	containers, err := ListAll()
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, c := range containers {
+		if c.ImageID == id {
+			ids = append(ids, c.ID)
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,125 @@
+package container
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	mrand "math/rand/v2"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// NameRoot 每个名字一个文件，内容是容器ID，O_EXCL创建保证名字不重复
+const NameRoot = "/var/lib/easydocker/names"
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+// 和docker一样用形容词_名人拼出随机名字
+var (
+	nameLeft = []string{
+		"admiring", "agitated", "amazing", "angry", "awesome", "blissful", "bold", "brave",
+		"busy", "charming", "clever", "cool", "dazzling", "determined", "eager", "ecstatic",
+		"elegant", "epic", "festive", "focused", "friendly", "gallant", "gifted", "goofy",
+		"happy", "hungry", "infallible", "jolly", "keen", "kind", "laughing", "loving",
+		"magical", "modest", "nervous", "nice", "nostalgic", "peaceful", "pensive", "quirky",
+		"relaxed", "romantic", "serene", "sharp", "silly", "stoic", "sweet", "tender",
+		"trusting", "upbeat", "vibrant", "vigilant", "wizardly", "wonderful", "youthful", "zealous",
+	}
+	nameRight = []string{
+		"agnesi", "archimedes", "babbage", "bell", "bohr", "curie", "darwin", "dijkstra",
+		"einstein", "euclid", "euler", "faraday", "fermat", "feynman", "galileo", "gauss",
+		"hamilton", "hawking", "hopper", "hypatia", "kepler", "knuth", "lamport", "leakey",
+		"lovelace", "mccarthy", "mendel", "newton", "nobel", "noether", "pasteur", "pike",
+		"poincare", "ramanujan", "ritchie", "shannon", "tesla", "thompson", "torvalds", "turing",
+		"volhard", "wozniak", "wright", "yalow",
+	}
+)
+
+// makeID 64位十六进制的随机ID，短ID全是数字的话重新生成，免得和数字搞混
+func makeID() string {
+	b := make([]byte, 32)
+	for {
+		rand.Read(b)
+		id := hex.EncodeToString(b)
+		if _, err := strconv.ParseUint(ShortID(id), 10, 64); err == nil {
+			continue
+		}
+		if _, err := os.Stat(path.Join(ContainerRoot, id)); err == nil {
+			continue
+		}
+		return id
+	}
+}
+
+func randomName(retry int) string {
+	name := nameLeft[mrand.IntN(len(nameLeft))] + "_" + nameRight[mrand.IntN(len(nameRight))]
+	if retry > 0 {
+		name += strconv.Itoa(mrand.IntN(10))
+	}
+	return name
+}
+
+// reserveName 占住名字，name为空就随机生成一个不重复的
+func reserveName(name, id string) (string, error) {
+	if err := os.MkdirAll(NameRoot, 0755); err != nil {
+		return "", fmt.Errorf("fail to create dir,%v", err)
+	}
+	if name != "" {
+		name = strings.TrimPrefix(name, "/")
+		if !validName.MatchString(name) {
+			return "", fmt.Errorf("invalid container name %q, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+		}
+		return name, claimName(name, id)
+	}
+	for retry := 0; ; retry++ {
+		name = randomName(retry)
+		err := claimName(name, id)
+		if err == nil {
+			return name, nil
+		}
+		if _, ok := err.(*NameConflictError); !ok || retry >= 10 {
+			return "", err
+		}
+	}
+}
+
+// NameConflictError 名字已经被别的容器用了
+type NameConflictError struct {
+	Name string
+	ID   string
+}
+
+func (e *NameConflictError) Error() string {
+	return fmt.Sprintf("the container name %q is already in use by container %q, remove or rename that container first", e.Name, e.ID)
+}
+
+func claimName(name, id string) error {
+	f, err := os.OpenFile(path.Join(NameRoot, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			owner, _ := os.ReadFile(path.Join(NameRoot, name))
+			return &NameConflictError{Name: name, ID: string(owner)}
+		}
+		return fmt.Errorf("fail to reserve name,%v", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(id); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("fail to reserve name,%v", err)
+	}
+	return nil
+}
+
+// releaseName 只删自己占的，防止删掉别人的
+func releaseName(name, id string) {
+	if name == "" {
+		return
+	}
+	owner, err := os.ReadFile(path.Join(NameRoot, name))
+	if err == nil && string(owner) == id {
+		os.Remove(path.Join(NameRoot, name))
+	}
+}
@@ -0,0 +1,55 @@
+package container
+
+import (
+	"docker/isolation"
+	"fmt"
+	"syscall"
+)
+
+// Pause 用cgroup freezer把容器里所有进程冻住
+func Pause(ID string) error {
+	c, err := Lookup(ID)
+	if err != nil {
+		return err
+	}
+	if err := checkTransition(c.ID, StatusPaused); err != nil {
+		return err
+	}
+	if err := isolation.NewCgroupManager(c.ID).Freeze(); err != nil {
+		return fmt.Errorf("fail to pause container,%v", err)
+	}
+	return updateContainer(c.ID, func(info *Container) error {
+		return info.State.setPaused(true)
+	})
+}
+
+func Unpause(ID string) error {
+	c, err := Lookup(ID)
+	if err != nil {
+		return err
+	}
+	if c.State.Status != StatusPaused {
+		return fmt.Errorf("container %s is not paused", c.ID)
+	}
+	if err := isolation.NewCgroupManager(c.ID).Thaw(); err != nil {
+		return fmt.Errorf("fail to unpause container,%v", err)
+	}
+	return updateContainer(c.ID, func(info *Container) error {
+		return info.State.setPaused(false)
+	})
+}
+
+// signal 冻住的进程要解冻才处理信号，stop和rm要等它退出，所以发完就解冻
+// 普通的kill信号就让它挂着，等unpause之后再处理
+func (c *Container) signal(sig syscall.Signal, thaw bool) error {
+	if err := syscall.Kill(c.State.Pid, sig); err != nil && err != syscall.ESRCH {
+		return fmt.Errorf("fail to signal container,%v", err)
+	}
+	if thaw && c.State.Status == StatusPaused {
+		//SIGKILL在新内核上冻住也能杀掉，进程没了cgroup也就删了，这时候不算错
+		if err := Unpause(c.ID); err != nil && isRunning(c.ID) {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,25 +1,82 @@
 package container
 
 import (
+	"docker/image"
 	"fmt"
 	"os"
 	"os/exec"
+	"strings"
 	"syscall"
 )
 
+// 和docker一样，镜像里没写PATH就用这个
+const defaultPath = "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
 type Process struct {
-	Command string
-	Args    []string
-	Env     []string
-	Dir     string
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+	Env     []string `json:"env"`
+	Dir     string   `json:"dir"`
+	User    string   `json:"user,omitempty"`
+	Tty     bool     `json:"tty,omitempty"`
 }
 
 func NewProcess(command string, args []string) *Process {
 	return &Process{
 		Command: command,
 		Args:    args,
-		Env:     []string{"PATH=/usr/bin:/bin"},
+		Env:     []string{defaultPath},
+		Dir:     "/",
+	}
+}
+
+// newProcessFromImage 按docker的规则合并：entrypoint+cmd，用户给了命令就替换掉cmd
+func newProcessFromImage(config *image.Config, command []string, tty bool) (*Process, error) {
+	argv := append([]string{}, config.Entrypoint...)
+	if len(command) > 0 {
+		argv = append(argv, command...)
+	} else {
+		argv = append(argv, config.Cmd...)
+	}
+	if len(argv) == 0 {
+		return nil, fmt.Errorf("no command specified")
+	}
+
+	p := NewProcess(argv[0], argv[1:])
+	p.Env = mergeEnv(p.Env, config.Env)
+	if tty {
+		p.Env = mergeEnv(p.Env, []string{"TERM=xterm"})
+		p.Tty = true
 	}
+	if config.WorkingDir != "" {
+		p.Dir = config.WorkingDir
+	}
+	p.User = config.User
+	return p, nil
+}
+
+// mergeEnv 后面的覆盖前面的同名变量，顺序按第一次出现的位置
+func mergeEnv(base, override []string) []string {
+	env := append([]string{}, base...)
+	index := map[string]int{}
+	for i, kv := range env {
+		key, _, _ := strings.Cut(kv, "=")
+		index[key] = i
+	}
+	for _, kv := range override {
+		key, _, _ := strings.Cut(kv, "=")
+		if i, ok := index[key]; ok {
+			env[i] = kv
+			continue
+		}
+		index[key] = len(env)
+		env = append(env, kv)
+	}
+	return env
+}
+
+func (p *Process) Argv() []string {
+	return append([]string{p.Command}, p.Args...)
 }
 
 func (p *Process) Start() error {
@@ -0,0 +1,49 @@
+package container
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RestartPolicy 和docker的--restart一样，空的就是no
+type RestartPolicy struct {
+	Name              string `json:"name"`
+	MaximumRetryCount int    `json:"maximum_retry_count"`
+}
+
+// ParseRestartPolicy no、always、unless-stopped、on-failure[:最多重试几次]
+func ParseRestartPolicy(s string) (RestartPolicy, error) {
+	name, count, hasCount := strings.Cut(s, ":")
+	policy := RestartPolicy{Name: name}
+	switch name {
+	case "", "no", "always", "unless-stopped":
+		if hasCount {
+			return policy, fmt.Errorf("maximum retry count cannot be used with restart policy '%s'", name)
+		}
+		if name == "" {
+			policy.Name = "no"
+		}
+	case "on-failure":
+		if hasCount {
+			n, err := strconv.Atoi(count)
+			if err != nil || n < 0 {
+				return policy, fmt.Errorf("invalid restart policy %q", s)
+			}
+			policy.MaximumRetryCount = n
+		}
+	default:
+		return policy, fmt.Errorf("invalid restart policy %q", s)
+	}
+	return policy, nil
+}
+
+func (p RestartPolicy) String() string {
+	if p.Name == "" {
+		return "no"
+	}
+	if p.Name == "on-failure" && p.MaximumRetryCount > 0 {
+		return fmt.Sprintf("%s:%d", p.Name, p.MaximumRetryCount)
+	}
+	return p.Name
+}
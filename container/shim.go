@@ -0,0 +1,256 @@
+package container
+
+import (
+	"docker/isolation"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// shim通过这个fd告诉CLI容器起来没有
+const shimPipeEnv = "_EASYDOCKER_SHIMPIPE"
+
+// startShim 起一个脱离终端的shim进程来管容器，CLI退出了shim和容器都还在
+// 等shim把容器真正跑起来才返回，启动失败的错误也从管道带回来
+func startShim(id string) error {
+	_, err := launchShim(id, false)
+	return err
+}
+
+// startShimAttached 带tty的前台run，shim等CLI连上console之后再启动容器
+func startShimAttached(id string) (*net.UnixConn, error) {
+	return launchShim(id, true)
+}
+
+func launchShim(id string, attach bool) (*net.UnixConn, error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("fail to create shim pipe,%v", err)
+	}
+	defer reader.Close()
+
+	devNull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		writer.Close()
+		return nil, err
+	}
+	defer devNull.Close()
+
+	args := []string{"shim", id}
+	if attach {
+		args = []string{"shim", "--attach", id}
+	}
+	cmd := exec.Command("/proc/self/exe", args...)
+	cmd.Stdin = devNull
+	cmd.Stdout = devNull
+	cmd.Stderr = devNull
+	cmd.ExtraFiles = []*os.File{writer}
+	cmd.Env = append(os.Environ(), shimPipeEnv+"=3")
+	//新的session，终端关了也收不到SIGHUP
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	writer.Close()
+	if err != nil {
+		return nil, fmt.Errorf("fail to start shim,%v", err)
+	}
+	//shim之后归init进程回收，这里不等它
+	defer cmd.Process.Release()
+
+	ready := make(chan error, 1)
+	go func() {
+		msg, err := io.ReadAll(reader)
+		if err != nil {
+			ready <- fmt.Errorf("fail to read shim pipe,%v", err)
+		} else if len(msg) > 0 {
+			ready <- errors.New(string(msg))
+		}
+		close(ready)
+	}()
+	if !attach {
+		return nil, <-ready
+	}
+
+	//shim建好socket之前连不上，一直重试到连上或者shim报错
+	var conn *net.UnixConn
+	for conn == nil {
+		select {
+		case err, ok := <-ready:
+			if !ok {
+				err = fmt.Errorf("shim exited before console was ready")
+			}
+			return nil, err
+		case <-time.After(10 * time.Millisecond):
+		}
+		conn, _ = net.DialUnix("unix", nil, &net.UnixAddr{Name: consolePath(id), Net: "unix"})
+	}
+	if err := <-ready; err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Shim 在shim进程里执行，一直陪着容器的init进程直到它退出
+// attach的时候先等CLI连上console
+func Shim(id string, attach bool) error {
+	if os.Getenv(shimPipeEnv) == "" {
+		return fmt.Errorf("shim can only be called by easydocker itself")
+	}
+	pipe := os.NewFile(3, "shim-pipe")
+	//不能漏给容器
+	syscall.CloseOnExec(3)
+
+	c, err := GetContainer(id)
+	if err != nil {
+		pipe.WriteString(err.Error())
+		pipe.Close()
+		return err
+	}
+	console, err := listenConsole(c.ID)
+	if err != nil {
+		pipe.WriteString(err.Error())
+		pipe.Close()
+		return err
+	}
+	defer console.Close()
+	if attach {
+		console.waitClient(10 * time.Second)
+	}
+	_, err = supervise(c, nil, console, func(err error) {
+		if err != nil {
+			pipe.WriteString(err.Error())
+		}
+		pipe.Close()
+	})
+	return err
+}
+
+// supervise 启动容器并一直等到init进程退出，真实pid和退出码都记到容器记录里
+// 前台run在CLI里直接调，后台的由shim调；started在容器跑起来或者启动失败的时候调一次
+// 输出总是写进日志并广播给attach上来的客户端，term不是nil的话同时接到终端上
+func supervise(c *Container, term *isolation.Stdio, console *consoleServer, started func(error)) (int, error) {
+	logger, err := openLogger(c.ID, c.LogConfig)
+	if err != nil {
+		started(err)
+		return -1, err
+	}
+	defer logger.Close()
+	stdout, stderr := logger.stream("stdout"), logger.stream("stderr")
+	stdio := &isolation.Stdio{
+		Stdout: io.MultiWriter(stdout, console.output(frameStdout)),
+		Stderr: io.MultiWriter(stderr, console.output(frameStderr)),
+	}
+	tty := c.Process != nil && c.Process.Tty
+	var stdinPipe *os.File
+	switch {
+	case term != nil:
+		stdio.Stdin = term.Stdin
+		if term.Stdout != nil {
+			stdio.Stdout = io.MultiWriter(term.Stdout, stdio.Stdout)
+		}
+		if term.Stderr != nil {
+			stdio.Stderr = io.MultiWriter(term.Stderr, stdio.Stderr)
+		}
+	case c.OpenStdin && !tty:
+		//后台的-i容器，输入从attach的客户端来
+		r, w, err := os.Pipe()
+		if err != nil {
+			started(err)
+			return -1, err
+		}
+		defer w.Close()
+		stdio.Stdin, stdinPipe = r, r
+		console.input = w
+	}
+
+	//先检查一遍，不然进程都起来了才发现状态不对
+	if err := checkTransition(c.ID, StatusRunning); err != nil {
+		started(err)
+		return -1, err
+	}
+	proc, master, err := isolation.StartContainer(c.initConfig(), stdio)
+	if stdinPipe != nil {
+		stdinPipe.Close()
+	}
+	if err != nil {
+		updateContainer(c.ID, func(info *Container) error {
+			return info.State.setStartError(err)
+		})
+		started(err)
+		return -1, err
+	}
+
+	err = updateContainer(c.ID, func(info *Container) error {
+		info.ShimPid = os.Getpid()
+		return info.State.setRunning(proc.Process.Pid)
+	})
+	if err != nil {
+		proc.Process.Kill()
+		proc.Wait()
+		started(err)
+		return -1, err
+	}
+	var consoleDone <-chan struct{}
+	if master != nil {
+		defer master.Close()
+		console.master = master
+		if c.OpenStdin {
+			console.input = master
+		}
+		consoleDone = console.copyConsole(master, stdout)
+	}
+	console.start()
+	started(nil)
+
+	//supervisor自己收到的信号转给容器，不然supervisor先退了容器就没人管了
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	defer signal.Stop(signals)
+	go func() {
+		for sig := range signals {
+			proc.Process.Signal(sig)
+		}
+	}()
+
+	code := exitCode(proc.Wait())
+	if consoleDone != nil {
+		<-consoleDone
+	}
+	//没换行的最后一段要在改状态之前写进日志，logs -f看到容器停了就不再读了
+	stdout.Close()
+	stderr.Close()
+	slog.Info("container exited", "id", c.ID, "code", code)
+	cgroup := isolation.NewCgroupManager(c.ID)
+	oomKilled := cgroup.OOMKilled()
+	//下次start重新建，oom的计数也就清零了
+	if err := cgroup.Remove(); err != nil {
+		slog.Warn("fail to remove cgroup", "id", c.ID, "error", err)
+	}
+	err = updateContainer(c.ID, func(info *Container) error {
+		info.ShimPid = 0
+		return info.State.setExited(code, oomKilled)
+	})
+	return code, err
+}
+
+// exitCode 和shell的规则一样，被信号杀掉的是128+信号
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return -1
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return exitErr.ExitCode()
+}
@@ -0,0 +1,79 @@
+package container
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// 和docker一样，没配置的话stop先发SIGTERM，等10秒再SIGKILL
+const (
+	defaultStopSignal  = syscall.SIGTERM
+	DefaultStopTimeout = 10
+)
+
+// ParseSignal TERM、SIGTERM、15都可以
+func ParseSignal(s string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n <= 0 || n > 64 {
+			return 0, fmt.Errorf("invalid signal %q", s)
+		}
+		return syscall.Signal(n), nil
+	}
+	name := strings.ToUpper(s)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	sig := unix.SignalNum(name)
+	if sig == 0 {
+		return 0, fmt.Errorf("invalid signal %q", s)
+	}
+	return sig, nil
+}
+
+// Kill 信号发给容器的1号进程，退出状态由supervise记录
+func Kill(ID string, signal string) error {
+	c, err := Lookup(ID)
+	if err != nil {
+		return err
+	}
+	sig := syscall.SIGKILL
+	if signal != "" {
+		if sig, err = ParseSignal(signal); err != nil {
+			return err
+		}
+	}
+	if !c.State.Running() {
+		return fmt.Errorf("container %s is not running", c.ID)
+	}
+	return c.signal(sig, sig == syscall.SIGKILL)
+}
+
+// stopSignal 命令行指定的优先，然后是容器创建时定的(包括镜像的StopSignal)
+func (c *Container) stopSignal(signal string) (syscall.Signal, error) {
+	if signal == "" {
+		signal = c.StopSignal
+	}
+	if signal == "" {
+		return defaultStopSignal, nil
+	}
+	return ParseSignal(signal)
+}
+
+// waitStopped 等supervise把容器记成停止，超时返回false
+func waitStopped(ID string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if !isRunning(ID) {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,149 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusCreated Status = "created"
+	StatusRunning Status = "running"
+	StatusPaused  Status = "paused"
+	StatusExited  Status = "exited"
+	//进程没了但是没人记下退出状态，比如前台run的CLI被kill -9
+	StatusDead Status = "dead"
+)
+
+// transitions 允许的状态变化，created启动失败直接算exited
+var transitions = map[Status][]Status{
+	StatusCreated: {StatusRunning, StatusExited, StatusDead},
+	StatusRunning: {StatusPaused, StatusExited, StatusDead},
+	StatusPaused:  {StatusRunning, StatusExited, StatusDead},
+	StatusExited:  {StatusRunning, StatusDead},
+	StatusDead:    {},
+}
+
+// State 和docker inspect里的State一样
+type State struct {
+	Status     Status    `json:"status"`
+	Pid        int       `json:"pid"`
+	ExitCode   int       `json:"exit_code"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	OOMKilled  bool      `json:"oom_killed"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// StateError 不允许的状态变化，比如对已经停掉的容器pause
+type StateError struct {
+	From Status
+	To   Status
+}
+
+func (e *StateError) Error() string {
+	return fmt.Sprintf("cannot change container state from %s to %s", e.From, e.To)
+}
+
+func (s *State) transition(to Status) error {
+	for _, next := range transitions[s.Status] {
+		if next == to {
+			s.Status = to
+			return nil
+		}
+	}
+	return &StateError{From: s.Status, To: to}
+}
+
+func (s *State) setRunning(pid int) error {
+	if err := s.transition(StatusRunning); err != nil {
+		return err
+	}
+	s.Pid = pid
+	s.ExitCode = 0
+	s.OOMKilled = false
+	s.Error = ""
+	s.StartedAt = time.Now()
+	s.FinishedAt = time.Time{}
+	return nil
+}
+
+func (s *State) setExited(code int, oomKilled bool) error {
+	if err := s.transition(StatusExited); err != nil {
+		return err
+	}
+	s.Pid = 0
+	s.ExitCode = code
+	s.OOMKilled = oomKilled
+	s.FinishedAt = time.Now()
+	return nil
+}
+
+// setStartError 启动失败，和docker一样退出码记成128
+func (s *State) setStartError(err error) error {
+	if e := s.setExited(128, false); e != nil {
+		return e
+	}
+	s.Error = err.Error()
+	return nil
+}
+
+func (s *State) setPaused(paused bool) error {
+	if paused {
+		return s.transition(StatusPaused)
+	}
+	if s.Status != StatusPaused {
+		return &StateError{From: s.Status, To: StatusRunning}
+	}
+	return s.transition(StatusRunning)
+}
+
+func (s *State) setDead(reason string) error {
+	if err := s.transition(StatusDead); err != nil {
+		return err
+	}
+	s.Pid = 0
+	s.Error = reason
+	s.FinishedAt = time.Now()
+	return nil
+}
+
+// Running paused的容器进程也还在
+func (s *State) Running() bool {
+	return s.Status == StatusRunning || s.Status == StatusPaused
+}
+
+// reconcile 记录说在跑但是进程和看管它的supervisor都没了，就标成dead
+// supervisor还在的话以它记的为准，它马上就会写退出码
+func (c *Container) reconcile() bool {
+	if !c.State.Running() {
+		return false
+	}
+	if alive(c.State.Pid) || alive(c.ShimPid) {
+		return false
+	}
+	c.State.setDead("container process exited without a supervisor")
+	c.ShimPid = 0
+	return true
+}
+
+// alive 僵尸进程也算没了，没人回收的孤儿init会一直是僵尸
+func alive(pid int) bool {
+	if pid <= 0 || syscall.Kill(pid, 0) != nil {
+		return false
+	}
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	//第三列是状态，前面的comm里可能有空格和括号，从最后一个)往后找
+	stat := string(data)
+	if i := strings.LastIndex(stat, ")"); i >= 0 && i+2 < len(stat) {
+		return stat[i+2] != 'Z'
+	}
+	return true
+}
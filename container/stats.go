@@ -0,0 +1,118 @@
+package container
+
+import (
+	"docker/isolation"
+	"docker/network"
+	"docker/storage"
+	"fmt"
+	"time"
+)
+
+// StatsEntry stats的一行，json输出的是原始的数字，表格里显示的是下面几个方法格式化过的
+type StatsEntry struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	//100%是一个核跑满
+	CPUPercentage    float64 `json:"cpu_percentage"`
+	MemoryUsage      uint64  `json:"memory_usage"`
+	MemoryLimit      uint64  `json:"memory_limit"`
+	MemoryPercentage float64 `json:"memory_percentage"`
+	NetworkRx        uint64  `json:"network_rx"`
+	NetworkTx        uint64  `json:"network_tx"`
+	BlockRead        uint64  `json:"block_read"`
+	BlockWrite       uint64  `json:"block_write"`
+	PIDs             uint64  `json:"pids"`
+}
+
+func (s *StatsEntry) CPUPerc() string {
+	return fmt.Sprintf("%.2f%%", s.CPUPercentage)
+}
+
+// MemUsage 内存按1024进位，网络和磁盘按1000，和docker一样
+func (s *StatsEntry) MemUsage() string {
+	return storage.BytesSize(int64(s.MemoryUsage)) + " / " + storage.BytesSize(int64(s.MemoryLimit))
+}
+
+func (s *StatsEntry) MemPerc() string {
+	return fmt.Sprintf("%.2f%%", s.MemoryPercentage)
+}
+
+func (s *StatsEntry) NetIO() string {
+	return storage.HumanSize(int64(s.NetworkRx)) + " / " + storage.HumanSize(int64(s.NetworkTx))
+}
+
+func (s *StatsEntry) BlockIO() string {
+	return storage.HumanSize(int64(s.BlockRead)) + " / " + storage.HumanSize(int64(s.BlockWrite))
+}
+
+// StatsCollector 记着每个容器上一次的cpu时间，两次采样之间的差就是这段时间用的cpu
+type StatsCollector struct {
+	prev map[string]cpuSample
+}
+
+type cpuSample struct {
+	usage uint64
+	read  time.Time
+}
+
+func NewStatsCollector() *StatsCollector {
+	return &StatsCollector{prev: map[string]cpuSample{}}
+}
+
+// Collect 指定了容器的话停掉的也显示一行0，没指定就是所有在跑的容器，每次都重新列，新起的容器也会出现
+func (s *StatsCollector) Collect(refs []string) ([]*StatsEntry, error) {
+	var list []*Container
+	if len(refs) == 0 {
+		all, err := List(ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		list = all
+	}
+	for _, ref := range refs {
+		c, err := Lookup(ref)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+	}
+
+	var entries []*StatsEntry
+	seen := map[string]bool{}
+	for _, c := range list {
+		entry := &StatsEntry{ID: ShortID(c.ID), Name: c.Name}
+		entries = append(entries, entry)
+		//刚好在两次之间停掉的容器cgroup已经删了，也显示0
+		if !c.State.Running() {
+			continue
+		}
+		stats, err := isolation.NewCgroupManager(c.ID).Stats()
+		if err != nil {
+			continue
+		}
+		now := time.Now()
+		seen[c.ID] = true
+		if prev, ok := s.prev[c.ID]; ok && stats.CPUUsage >= prev.usage {
+			if wall := now.Sub(prev.read); wall > 0 {
+				entry.CPUPercentage = float64(stats.CPUUsage-prev.usage) / float64(wall.Nanoseconds()) * 100
+			}
+		}
+		s.prev[c.ID] = cpuSample{usage: stats.CPUUsage, read: now}
+
+		entry.MemoryUsage, entry.MemoryLimit = stats.MemoryUsage, stats.MemoryLimit
+		if stats.MemoryLimit > 0 {
+			entry.MemoryPercentage = float64(stats.MemoryUsage) / float64(stats.MemoryLimit) * 100
+		}
+		entry.BlockRead, entry.BlockWrite = stats.BlockRead, stats.BlockWrite
+		entry.PIDs = stats.Pids
+		//没有网络的容器就是0
+		entry.NetworkRx, entry.NetworkTx, _ = network.GetNetStats(c.State.Pid)
+	}
+	//停掉又重新启动的容器cpu时间从0开始，不能和之前的比
+	for id := range s.prev {
+		if !seen[id] {
+			delete(s.prev, id)
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,145 @@
+package container
+
+import (
+	"docker/isolation"
+	"docker/storage"
+	"fmt"
+	"strconv"
+)
+
+// UpdateOptions 资源限制里是0的和空的重启策略都是不改
+type UpdateOptions struct {
+	Resources     isolation.Resources
+	RestartPolicy string
+}
+
+// Change 改了的配置，改之前和改之后的值
+type Change struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// Update 在跑的容器直接改cgroup，停掉的下次启动生效，新的值都存进容器配置
+func Update(ID string, opts *UpdateOptions) ([]Change, error) {
+	c, err := Lookup(ID)
+	if err != nil {
+		return nil, err
+	}
+	var policy *RestartPolicy
+	if opts.RestartPolicy != "" {
+		p, err := ParseRestartPolicy(opts.RestartPolicy)
+		if err != nil {
+			return nil, err
+		}
+		policy = &p
+	}
+
+	var changes []Change
+	err = updateContainer(c.ID, func(info *Container) error {
+		r := mergeResources(info.Resources, opts.Resources)
+		//只改了memory，比原来的memory-swap还大
+		if opts.Resources.Memory > 0 && opts.Resources.MemorySwap == 0 && r.MemorySwap > 0 && r.MemorySwap < r.Memory {
+			return fmt.Errorf("memory limit should be smaller than already set memoryswap limit, update the memoryswap at the same time")
+		}
+		if err := r.Validate(); err != nil {
+			return err
+		}
+		if info.State.Running() {
+			if err := isolation.NewCgroupManager(info.ID).Set(&r); err != nil {
+				return fmt.Errorf("fail to update container %s,%v", info.ID, err)
+			}
+		}
+		changes = resourceChanges(&info.Resources, &r)
+		info.Resources = r
+		if policy != nil && *policy != info.RestartPolicy {
+			changes = append(changes, Change{"restart", info.RestartPolicy.String(), policy.String()})
+			info.RestartPolicy = *policy
+		}
+		return nil
+	})
+	return changes, err
+}
+
+// mergeResources 新给的覆盖原来的，--cpus是quota和period一起改
+func mergeResources(old, update isolation.Resources) isolation.Resources {
+	r := old
+	if update.Memory != 0 {
+		r.Memory = update.Memory
+	}
+	if update.MemorySwap != 0 {
+		r.MemorySwap = update.MemorySwap
+	}
+	if update.MemoryReservation != 0 {
+		r.MemoryReservation = update.MemoryReservation
+	}
+	if update.CPUShares != 0 {
+		r.CPUShares = update.CPUShares
+	}
+	if update.CPUQuota != 0 {
+		r.CPUQuota, r.CPUPeriod = update.CPUQuota, update.CPUPeriod
+	}
+	if update.CpusetCpus != "" {
+		r.CpusetCpus = update.CpusetCpus
+	}
+	if update.CpusetMems != "" {
+		r.CpusetMems = update.CpusetMems
+	}
+	if update.PidsLimit != 0 {
+		r.PidsLimit = update.PidsLimit
+	}
+	if update.BlkioWeight != 0 {
+		r.BlkioWeight = update.BlkioWeight
+	}
+	return r
+}
+
+func resourceChanges(old, r *isolation.Resources) []Change {
+	fields := []Change{
+		{"memory", bytesLimit(old.Memory), bytesLimit(r.Memory)},
+		{"memory-swap", bytesLimit(old.MemorySwap), bytesLimit(r.MemorySwap)},
+		{"memory-reservation", bytesLimit(old.MemoryReservation), bytesLimit(r.MemoryReservation)},
+		{"cpus", cpusLimit(old.CPUs()), cpusLimit(r.CPUs())},
+		{"cpu-shares", orDefault(old.CPUShares, "default"), orDefault(r.CPUShares, "default")},
+		{"cpuset-cpus", orAll(old.CpusetCpus), orAll(r.CpusetCpus)},
+		{"cpuset-mems", orAll(old.CpusetMems), orAll(r.CpusetMems)},
+		{"pids-limit", orDefault(old.PidsLimit, "unlimited"), orDefault(r.PidsLimit, "unlimited")},
+		{"blkio-weight", orDefault(int64(old.BlkioWeight), "default"), orDefault(int64(r.BlkioWeight), "default")},
+	}
+	var changes []Change
+	for _, f := range fields {
+		if f.Old != f.New {
+			changes = append(changes, f)
+		}
+	}
+	return changes
+}
+
+// 0和-1都是不限制
+func bytesLimit(v int64) string {
+	if v <= 0 {
+		return "unlimited"
+	}
+	return storage.BytesSize(v)
+}
+
+func cpusLimit(cpus float64) string {
+	if cpus <= 0 {
+		return "unlimited"
+	}
+	return strconv.FormatFloat(cpus, 'f', -1, 64)
+}
+
+func orDefault(v int64, def string) string {
+	if v <= 0 {
+		return def
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+func orAll(cpuset string) string {
+	if cpuset == "" {
+		return "all"
+	}
+	return cpuset
+}
@@ -0,0 +1,136 @@
+package image
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	authDir  = ".easydocker"
+	authFile = "config.json"
+)
+
+// AuthConfig 和docker的~/.docker/config.json格式一样
+type AuthConfig struct {
+	Auths map[string]AuthEntry `json:"auths"`
+}
+
+type AuthEntry struct {
+	//base64(username:password)
+	Auth string `json:"auth"`
+}
+
+func authConfigPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("fail to get home dir,%v", err)
+	}
+	return path.Join(home, authDir, authFile), nil
+}
+
+// 统一去掉协议和路径，docker.io的各种写法都算docker.io
+func normalizeRegistry(registry string) string {
+	registry = strings.TrimPrefix(registry, "https://")
+	registry = strings.TrimPrefix(registry, "http://")
+	registry, _, _ = strings.Cut(registry, "/")
+	switch registry {
+	case "", "index.docker.io", defaultEndpoint:
+		return defaultRegistry
+	}
+	return registry
+}
+
+func LoadAuthConfig() (*AuthConfig, error) {
+	configPath, err := authConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	config := &AuthConfig{Auths: map[string]AuthEntry{}}
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return config, nil
+		}
+		return nil, fmt.Errorf("fail to read auth config,%v", err)
+	}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("fail to parse auth config,%v", err)
+	}
+	if config.Auths == nil {
+		config.Auths = map[string]AuthEntry{}
+	}
+	return config, nil
+}
+
+func saveAuthConfig(config *AuthConfig) error {
+	configPath, err := authConfigPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(configPath), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(config, "", "	")
+	if err != nil {
+		return err
+	}
+	//里面是密码，只给自己看
+	return os.WriteFile(configPath, data, 0600)
+}
+
+// GetAuth 没登录过返回nil
+func GetAuth(registry string) (*AuthEntry, error) {
+	config, err := LoadAuthConfig()
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := config.Auths[normalizeRegistry(registry)]
+	if !ok || entry.Auth == "" {
+		return nil, nil
+	}
+	return &entry, nil
+}
+
+// Login 先去registry校验一遍账号密码，通过了才写进配置文件
+func Login(registry, username, password string) error {
+	registry = normalizeRegistry(registry)
+	if username == "" || password == "" {
+		return fmt.Errorf("empty username or password")
+	}
+	entry := &AuthEntry{
+		Auth: base64.StdEncoding.EncodeToString([]byte(username + ":" + password)),
+	}
+
+	client, err := newRegistryClient(registry, "")
+	if err != nil {
+		return err
+	}
+	client.auth = entry
+	if err := client.ping(); err != nil {
+		return err
+	}
+
+	config, err := LoadAuthConfig()
+	if err != nil {
+		return err
+	}
+	config.Auths[registry] = *entry
+	return saveAuthConfig(config)
+}
+
+func Logout(registry string) error {
+	registry = normalizeRegistry(registry)
+	config, err := LoadAuthConfig()
+	if err != nil {
+		return err
+	}
+	if _, ok := config.Auths[registry]; !ok {
+		return fmt.Errorf("not logged in to %s", registry)
+	}
+	delete(config.Auths, registry)
+	return saveAuthConfig(config)
+}
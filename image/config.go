@@ -0,0 +1,78 @@
+package image
+
+import (
+	"docker/storage"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+const imageConfigFile = "config.json"
+
+// Config 镜像里容器运行相关的默认配置，字段名和docker的保持一致
+type Config struct {
+	User         string              `json:"User,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	Volumes      map[string]struct{} `json:"Volumes,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	StopSignal   string              `json:"StopSignal,omitempty"`
+}
+
+type RootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+// ImageConfig 对应config blob的整个内容
+type ImageConfig struct {
+	Architecture string    `json:"architecture"`
+	OS           string    `json:"os"`
+	Variant      string    `json:"variant,omitempty"`
+	Created      time.Time `json:"created"`
+	Config       Config    `json:"config"`
+	RootFS       RootFS    `json:"rootfs"`
+}
+
+func parseImageConfig(blobs *storage.BlobStore, digest string) (*ImageConfig, error) {
+	f, err := blobs.Open(digest)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	var config ImageConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("fail to parse image config,%v", err)
+	}
+	return &config, nil
+}
+
+func saveImageConfig(config *ImageConfig, imagePath string) error {
+	data, err := json.MarshalIndent(config, "", "	")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(imagePath, imageConfigFile), data, 0644)
+}
+
+func GetImageConfig(id string) (*ImageConfig, error) {
+	data, err := os.ReadFile(path.Join(storage.ImagePath(id), imageConfigFile))
+	if err != nil {
+		return nil, err
+	}
+	var config ImageConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("fail to parse image config,%v", err)
+	}
+	return &config, nil
+}
@@ -1,8 +1,7 @@
 package image
 
 import (
-	"archive/tar"
-	"compress/gzip"
+	"context"
 	"crypto/sha256"
 	"docker/storage"
 	"encoding/json"
@@ -13,6 +12,8 @@ import (
 	"os"
 	"path"
 	"strings"
+	"sync"
+	"time"
 )
 
 type Image struct {
@@ -21,73 +22,116 @@ type Image struct {
 	Size int64
 }
 
+const (
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+)
+
+// 四种都要带上，不然registry会退回schema1
+var manifestAccept = []string{
+	mediaTypeDockerManifest,
+	mediaTypeDockerManifestList,
+	mediaTypeOCIManifest,
+	mediaTypeOCIIndex,
+}
+
 type descriptor struct {
-	Type   string `json:"type"`
-	Size   int64  `json:"size"`
-	Digest string `json:"digest"`
+	MediaType string    `json:"mediaType"`
+	Size      int64     `json:"size"`
+	Digest    string    `json:"digest"`
+	Platform  *Platform `json:"platform,omitempty"`
 }
 type manifest struct {
 	SchemaVersion int          `json:"schemaVersion"`
-	Type          string       `json:"type"`
+	MediaType     string       `json:"mediaType"`
 	Config        descriptor   `json:"config"`
 	Layers        []descriptor `json:"layers"`
+	//只有manifest list/index才有
+	Manifests []descriptor `json:"manifests,omitempty"`
 }
 
-func parse(imagename string) (registry, repo, tag string, err error) {
-	part := strings.SplitN(imagename, ":", 2)
-	name := part[0]
-	tag = "latest"
-	if len(part) > 1 {
-		tag = part[1]
+func (m *manifest) isIndex(contentType string) bool {
+	switch m.MediaType {
+	case mediaTypeDockerManifestList, mediaTypeOCIIndex:
+		return true
+	case mediaTypeDockerManifest, mediaTypeOCIManifest:
+		return false
 	}
-
-	if strings.Contains(name, ":") {
-		registryRepo := strings.SplitN(name, "/", 2)
-		if len(registryRepo) != 2 {
-			return "", "", "", fmt.Errorf("fail to find image: %s", name)
-		}
-		registry = registryRepo[0]
-		repo = registryRepo[1]
-	} else {
-		registry = "docker.io"
-		if !strings.Contains(name, "/") {
-			repo = "library/" + name
-		} else {
-			repo = name
-		}
+	//oci的index里mediaType是可选的，只能看header或者有没有manifests字段
+	switch contentType {
+	case mediaTypeDockerManifestList, mediaTypeOCIIndex:
+		return true
 	}
-	return registry, repo, tag, nil
+	return len(m.Manifests) > 0 && len(m.Layers) == 0
 }
 
-func getImageManifest(registry, repo, tag string) (*manifest, error) {
-	url := fmt.Sprintf("https://%s/v2/%s/manifests/%s", registry, repo, tag)
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json")
-	req.Header.Set("User-Agent", "easydocker/1.0")
+// fetchManifest 返回manifest、Content-Type和内容的digest
+func fetchManifest(client *registryClient, reference string) (*manifest, string, string, error) {
+	header := http.Header{}
+	header.Set("Accept", strings.Join(manifestAccept, ", "))
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := client.get(client.url("/v2/%s/manifests/%s", client.repo, reference), header)
 	if err != nil {
-		url = fmt.Sprintf("https://%s/v2/%s/manifests/%s", registry, repo, tag)
-		res, err = http.Get(url)
-		if err != nil {
-			return nil, fmt.Errorf("fail to get image manifest: %s", err)
-		}
+		return nil, "", "", fmt.Errorf("fail to get image manifest: %s", err)
 	}
 	defer res.Body.Close()
 
 	//其实点进去发现http.StatusOK完全就是200吧。。。真的有必要封装这个吗
 	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("fail to get  manifest")
+		return nil, "", "", fmt.Errorf("fail to get  manifest,status=%d", res.StatusCode)
+	}
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("fail to read manifest,%v", err)
+	}
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	//按digest拿的话顺便校验一下内容
+	if strings.HasPrefix(reference, "sha256:") && digest != reference {
+		return nil, "", "", fmt.Errorf("manifest digest do not match,want %s,got %s", reference, digest)
 	}
 
 	var m manifest
-	if err := json.NewDecoder(res.Body).Decode(&m); err != nil {
-		return nil, fmt.Errorf("fail to parse manifest,%v", err)
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, "", "", fmt.Errorf("fail to parse manifest,%v", err)
 	}
-	return &m, nil
+	contentType, _, _ := strings.Cut(res.Header.Get("Content-Type"), ";")
+	return &m, strings.TrimSpace(contentType), digest, nil
+}
+
+// getImageManifest 拿到的是list/index的话再按平台挑一个真正的manifest
+// 返回的digest是tag直接指向的那个，和docker的RepoDigest一致
+func getImageManifest(client *registryClient, tag string, platform Platform) (*manifest, string, error) {
+	m, contentType, digest, err := fetchManifest(client, tag)
+	if err != nil {
+		return nil, "", err
+	}
+	if !m.isIndex(contentType) {
+		if m.MediaType == "" {
+			m.MediaType = contentType
+		}
+		return m, digest, nil
+	}
+
+	des, err := selectManifest(m.Manifests, platform)
+	if err != nil {
+		return nil, "", err
+	}
+	slog.Info("selected manifest", "platform", platform.String(), "digest", des.Digest)
+
+	m, contentType, _, err = fetchManifest(client, des.Digest)
+	if err != nil {
+		return nil, "", err
+	}
+	if m.isIndex(contentType) {
+		return nil, "", fmt.Errorf("nested manifest list is not supported")
+	}
+	if m.MediaType == "" {
+		m.MediaType = des.MediaType
+	}
+	return m, digest, nil
 }
 
 func saveManifest(m *manifest, imagePath string) error {
@@ -100,163 +144,300 @@ func saveManifest(m *manifest, imagePath string) error {
 	return json.NewEncoder(f).Encode(m)
 }
 
-func getBlobPath(digest, blobRoot string) string {
-	hash := strings.TrimPrefix(digest, "sha256:")
-	return path.Join(blobRoot, "sha256", hash)
-}
+// 一次pull里下载失败重试的次数，每次都从.partial接着下
+const downloadRetries = 3
 
-func verify(blobPath, digest string, size int64) error {
-	f, err := os.Stat(blobPath)
-	if err != nil {
-		return err
-	}
-	if f.Size() != size {
-		return fmt.Errorf("error size")
-	}
-	ff, err := os.Open(blobPath)
+// MaxConcurrentDownloads 同时下载的layer数
+var MaxConcurrentDownloads = 3
+
+// downloadImage 同一个blob加文件锁，两个进程同时pull只会有一个真正去下载
+// 后面的拿到锁的时候blob已经在了，直接用；ctx取消了就不再重试，下了一半的留在.partial里
+func downloadImage(ctx context.Context, client *registryClient, blobs *storage.BlobStore, des descriptor, p *progress) error {
+	id := ShortID(des.Digest)
+	lock, err := blobs.Lock(des.Digest)
 	if err != nil {
 		return err
 	}
-	defer ff.Close()
+	defer lock.Unlock()
 
-	hash := sha256.New()
-	if _, err := io.Copy(hash, ff); err != nil {
-		return err
+	if blobs.Has(des.Digest) {
+		p.update(id, "Already exists", "")
+		return nil
 	}
-	Digest := fmt.Sprintf("sha256:%x", hash.Sum(nil))
-	if Digest != digest {
-		return fmt.Errorf("error hash")
+
+	for i := 1; ; i++ {
+		err = fetchBlob(ctx, client, blobs, des, p)
+		if err == nil {
+			p.update(id, "Download complete", "")
+			return nil
+		}
+		if i >= downloadRetries || ctx.Err() != nil {
+			return err
+		}
+		slog.Warn("fail to download blob, retrying", "digest", des.Digest, "attempt", i, "error", err)
+		p.update(id, "Retrying", "")
+		select {
+		case <-time.After(time.Duration(i) * time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
-	return nil
 }
 
-func downloadImage(registry, repo, blobRoot string, des descriptor) error {
-	blobPath := getBlobPath(des.Digest, blobRoot)
-	if err := verify(blobPath, des.Digest, des.Size); err != nil {
-		slog.Warn("blob already exist")
-		return err
+// fetchBlob 已经有一部分的话用Range接着下
+func fetchBlob(ctx context.Context, client *registryClient, blobs *storage.BlobStore, des descriptor, p *progress) error {
+	offset := blobs.Partial(des.Digest)
+	if offset >= des.Size {
+		//刚好够但是上次没校验过，重新下
+		blobs.Abort(des.Digest)
+		offset = 0
+	}
+
+	header := http.Header{}
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
 	}
-	url := fmt.Sprintf("https://%s/v2/%s/blobs/%s", registry, repo, des.Digest)
-	res, err := http.Get(url)
+	res, err := client.getWithContext(ctx, client.url("/v2/%s/blobs/%s", client.repo, des.Digest), header)
 	if err != nil {
 		return fmt.Errorf("fail to download blob,%v", err)
 	}
 	defer res.Body.Close()
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("fail to download blob")
-	}
 
-	if err := os.MkdirAll(path.Dir(blobPath), 0755); err != nil {
-		return err
+	switch res.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		//服务器不支持Range，从头来
+		if offset > 0 {
+			if err := blobs.Abort(des.Digest); err != nil {
+				return err
+			}
+			offset = 0
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		blobs.Abort(des.Digest)
+		return fmt.Errorf("fail to download blob,status=%d", res.StatusCode)
+	default:
+		return fmt.Errorf("fail to download blob,status=%d", res.StatusCode)
 	}
-	f, err := os.Create(blobPath)
+
+	body := &progressReader{r: res.Body, p: p, id: ShortID(des.Digest), total: des.Size, current: offset}
+	return blobs.Ingest(des.Digest, des.Size, body)
+}
+
+// ExtractLayer 把一层解压到storage.LayerPath下，同一层只解压一次，不同镜像共用
+func ExtractLayer(blobs *storage.BlobStore, digest string) error {
+	layerPath := storage.LayerPath(digest)
+	layerDir := path.Dir(layerPath)
+	lock, err := storage.Lock(layerDir + ".lock")
 	if err != nil {
-		return fmt.Errorf("fail to create blob,%v", err)
+		return err
 	}
-	defer f.Close()
+	defer lock.Unlock()
 
-	hash := sha256.New()
-	tee := io.TeeReader(res.Body, hash)
-	if _, err := io.Copy(f, tee); err != nil {
-		return fmt.Errorf("fail to write blob,%v", err)
+	//committed最后写，没有的话说明上次解压到一半，清掉重来
+	committed := path.Join(layerDir, "committed")
+	if _, err := os.Stat(committed); err == nil {
+		return nil
 	}
-	Digest := fmt.Sprintf("sha256:%x", hash.Sum(nil))
-	if Digest != des.Digest {
-		os.Remove(blobPath)
-		return fmt.Errorf("hash do not match")
+	if err := os.RemoveAll(layerDir); err != nil {
+		return err
 	}
-	if ff, _ := f.Stat(); ff.Size() != des.Size {
-		os.Remove(blobPath)
-		return fmt.Errorf("size do not match")
+	if err := os.MkdirAll(layerPath, 0755); err != nil {
+		return err
 	}
-	return nil
-}
 
-func ExtractImage(imagepath, des string) error {
-	file, err := os.Open(imagepath)
+	file, err := blobs.Open(digest)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
-	gz, err := gzip.NewReader(file)
-	if err != nil {
+	if err := ApplyLayerDiff(file, layerPath); err != nil {
 		return err
 	}
-	defer gz.Close()
-
-	tars := tar.NewReader(gz)
-
-	for {
-		header, err := tars.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return err
-		}
+	return os.WriteFile(committed, nil, 0644)
+}
 
-		targetPath := path.Join(des, header.Name)
+// removeLayer 删掉ExtractLayer解压出来的目录和锁，config这种没解压过的直接跳过
+// committed先删，删到一半挂了下次会当成没解压完重新来
+func removeLayer(digest string) error {
+	layerDir := path.Dir(storage.LayerPath(digest))
+	_, dirErr := os.Stat(layerDir)
+	_, lockErr := os.Stat(layerDir + ".lock")
+	if os.IsNotExist(dirErr) && os.IsNotExist(lockErr) {
+		return nil
+	}
+	lock, err := storage.Lock(layerDir + ".lock")
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
 
-		if header.Typeflag == tar.TypeDir {
-			if err := os.MkdirAll(targetPath, 0777); err != nil {
-				return err
-			}
-		} else if header.Typeflag == tar.TypeReg {
-			out, err := os.Create(targetPath)
-			if err != nil {
-				return err
-			}
-			if _, err := io.Copy(out, tars); err != nil {
-				out.Close()
-				return err
-			}
-			out.Close()
-			os.Chmod(targetPath, os.FileMode(header.Mode))
-		}
+	if err := os.Remove(path.Join(layerDir, "committed")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.RemoveAll(layerDir); err != nil {
+		return err
+	}
+	if err := os.Remove(layerDir + ".lock"); err != nil && !os.IsNotExist(err) {
+		return err
 	}
 	return nil
 }
 
-func Pull(name string) error {
-	slog.Info("pulling image:", name)
+// Pull out不为nil的时候输出每一层的下载进度，返回镜像ID
+func Pull(name, platform string, out io.Writer) (string, error) {
+	slog.Info("pulling image", "name", name, "platform", platform)
+
+	plat, err := ParsePlatform(platform)
+	if err != nil {
+		return "", err
+	}
+
+	ref, err := ParseReference(name)
+	if err != nil {
+		return "", fmt.Errorf("fail to parse image %s,%v", name, err)
+	}
+	slog.Debug("pulling image", "registry", ref.Registry, "repo", ref.Repository, "reference", ref.manifestReference())
 
-	registry, repo, tag, err := parse(name)
+	client, err := newRegistryClient(ref.Registry, ref.Repository)
+	if err != nil {
+		return "", fmt.Errorf("fail to create registry client,%v", err)
+	}
+	manifest, digest, err := getImageManifest(client, ref.manifestReference(), plat)
 	if err != nil {
-		return fmt.Errorf("fail to parse image: registry=%s,repo=%s,tag=%s,err=%v", registry, repo, tag, err)
+		return "", fmt.Errorf("fail to get image manifest: registry=%s,repo=%s,reference=%s,%v", ref.Registry, ref.Repository, ref.manifestReference(), err)
+	}
+
+	p := newProgress(out)
+	p.printf("%s: Pulling from %s\n", ref.manifestReference(), ref.FamiliarName())
+	blobs := storage.NewBlobStore(storage.BlobRoot)
+	if err := downloadImage(context.Background(), client, blobs, manifest.Config, nil); err != nil {
+		return "", fmt.Errorf("fail to download blob,%v", err)
 	}
-	slog.Debug("pulling image:registry=%s,repo=%s,tag=%s,err=%v", registry, repo, tag, err)
+	//镜像ID就是config的digest，内容一样的镜像只存一份
+	id := manifest.Config.Digest
+	imagePath := storage.ImagePath(id)
 
-	manifest, err := getImageManifest(registry, repo, tag)
+	//同一个镜像同时只让一个进程解压，后来的等前面的做完直接用
+	lock, err := storage.Lock(imagePath + ".lock")
 	if err != nil {
-		return fmt.Errorf("fail to get image manifest: registry=%s,repo=%s,tag=%s", name, repo, tag)
+		return "", err
+	}
+	defer lock.Unlock()
+
+	status := "Image is up to date for " + ref.FamiliarName()
+	if _, err := storage.LoadImageMetadata(id); err != nil {
+		os.RemoveAll(imagePath)
+		status = "Downloaded newer image for " + ref.FamiliarName()
+		if err := pullImage(client, blobs, manifest, imagePath, p); err != nil {
+			os.RemoveAll(imagePath)
+			return "", err
+		}
+		config, err := GetImageConfig(id)
+		if err != nil {
+			return "", err
+		}
+		size := manifest.Config.Size
+		for _, layer := range manifest.Layers {
+			size += layer.Size
+		}
+		//metadata最后写，有metadata就说明镜像是完整的
+		if err := storage.SaveImageMetadata(&storage.ImageMetadata{
+			ID:      id,
+			Name:    ref.String(),
+			Version: ref.Tag,
+			Size:    size,
+			Digest:  digest,
+			Created: config.Created,
+		}); err != nil {
+			return "", fmt.Errorf("fail to save image metadata,%v", err)
+		}
+	}
+
+	if err := storage.UpdateReferenceStore(func(store *storage.ReferenceStore) error {
+		if ref.Tag != "" {
+			store.Add(ref.Name(), ref.Name()+":"+ref.Tag, id)
+		}
+		store.Add(ref.Name(), ref.Name()+"@"+digest, id)
+		return nil
+	}); err != nil {
+		return "", fmt.Errorf("fail to save reference,%v", err)
 	}
 
-	imagePath := path.Join(storage.ImageRoot, name)
+	p.printf("Digest: %s\nStatus: %s\n", digest, status)
+	return id, nil
+}
+
+func pullImage(client *registryClient, blobs *storage.BlobStore, manifest *manifest, imagePath string, p *progress) error {
 	if err := os.MkdirAll(imagePath, 0755); err != nil {
 		return fmt.Errorf("fail to create image dir,%v", err)
 	}
-	rootfsPath := path.Join(imagePath, "rootfs")
-	if err := os.MkdirAll(rootfsPath, 0755); err != nil {
-		return fmt.Errorf("fail to create rootfs dir,%v", err)
-	}
 
 	if err := saveManifest(manifest, imagePath); err != nil {
 		return fmt.Errorf("fail to save manifest,%v", err)
 	}
+	config, err := parseImageConfig(blobs, manifest.Config.Digest)
+	if err != nil {
+		return err
+	}
+	if err := saveImageConfig(config, imagePath); err != nil {
+		return fmt.Errorf("fail to save image config,%v", err)
+	}
 
-	if err := downloadImage(registry, repo, storage.BlobRoot, manifest.Config); err != nil {
-		return fmt.Errorf("fail to download blob,%v", err)
+	for _, layer := range manifest.Layers {
+		p.update(ShortID(layer.Digest), "Waiting", "")
 	}
 
+	//下载并发，解压必须按顺序，每一层一个channel通知下载完成
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make([]chan error, len(manifest.Layers))
+	limit := make(chan struct{}, max(MaxConcurrentDownloads, 1))
+	var wg sync.WaitGroup
 	for i, layer := range manifest.Layers {
-		slog.Info("layer:", i+1, layer.Digest)
-		if err := downloadImage(registry, repo, storage.BlobRoot, layer); err != nil {
+		done[i] = make(chan error, 1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case limit <- struct{}{}:
+			case <-ctx.Done():
+				done[i] <- ctx.Err()
+				return
+			}
+			defer func() { <-limit }()
+			slog.Info("pulling layer", "index", i+1, "digest", layer.Digest)
+			err := downloadImage(ctx, client, blobs, layer, p)
+			if err != nil {
+				//已经被别的层取消了的，报的错不是真正的原因
+				if ctx.Err() != nil {
+					err = ctx.Err()
+				}
+				cancel()
+			}
+			done[i] <- err
+		}()
+	}
+	//任何一层下载失败就取消其他还在下的层，.partial留着下次接着下
+	//提前返回也要等下载的goroutine退出，不然锁和文件还被占着，defer倒着执行，cancel在wg.Wait前面
+	defer wg.Wait()
+	defer cancel()
+
+	for i, layer := range manifest.Layers {
+		if err := <-done[i]; err != nil {
+			//后面的层先失败的话这一层收到的只是取消，去找真正出错的那层
+			for j := i + 1; err == context.Canceled && j < len(done); j++ {
+				if e := <-done[j]; e != nil && e != context.Canceled {
+					err, i = e, j
+				}
+			}
 			return fmt.Errorf("fail to download in layer:%d,%v", i+1, err)
 		}
-		layerPath := getBlobPath(layer.Digest, storage.Root)
-		if err := ExtractImage(layerPath, rootfsPath); err != nil {
+		id := ShortID(layer.Digest)
+		p.update(id, "Extracting", "")
+		if err := ExtractLayer(blobs, layer.Digest); err != nil {
 			return fmt.Errorf("fail to extract in layer:%d,digest:%s,%v", i+1, layer.Digest, err)
 		}
+		p.update(id, "Pull complete", "")
 	}
 	return nil
 }
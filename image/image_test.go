@@ -0,0 +1,76 @@
+package image
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"docker/storage"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func blobDigest(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+// 一层下载失败，其他还在下的层马上取消，下了一半的留着下次接着下
+func TestPullImageCancelOnError(t *testing.T) {
+	slow := bytes.Repeat([]byte("a"), 4096)
+	broken := []byte("broken layer")
+	config := []byte(`{"architecture":"amd64","os":"linux"}`)
+	cancelled := make(chan struct{})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case strings.HasSuffix(req.URL.Path, blobDigest(slow)):
+			//先给一半，然后一直卡着直到客户端断开
+			w.Header().Set("Content-Length", fmt.Sprint(len(slow)))
+			w.Write(slow[:len(slow)/2])
+			w.(http.Flusher).Flush()
+			select {
+			case <-req.Context().Done():
+				close(cancelled)
+			case <-time.After(30 * time.Second):
+			}
+		default:
+			http.Error(w, "boom", http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+	writeAuthConfig(t, srv.Listener.Addr().String(), "", "")
+	client, err := newRegistryClient(srv.Listener.Addr().String(), "library/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blobs := storage.NewBlobStore(t.TempDir())
+	if err := blobs.Ingest(blobDigest(config), int64(len(config)), bytes.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+	m := &manifest{
+		Config: descriptor{Digest: blobDigest(config), Size: int64(len(config))},
+		Layers: []descriptor{
+			{Digest: blobDigest(slow), Size: int64(len(slow))},
+			{Digest: blobDigest(broken), Size: int64(len(broken))},
+		},
+	}
+
+	err = pullImage(client, blobs, m, t.TempDir(), nil)
+	if err == nil || !strings.Contains(err.Error(), "layer:2") || !strings.Contains(err.Error(), "status=500") {
+		t.Fatalf("expect layer 2 error, got %v", err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow layer download was not cancelled")
+	}
+	if got := blobs.Partial(blobDigest(slow)); got != int64(len(slow)/2) {
+		t.Errorf("partial blob %d bytes, want %d", got, len(slow)/2)
+	}
+	if blobs.Has(blobDigest(slow)) {
+		t.Error("cancelled blob should not be committed")
+	}
+}
@@ -0,0 +1,72 @@
+package image
+
+import (
+	"docker/storage"
+	"time"
+)
+
+// ImageInspect inspect命令输出的镜像信息
+type ImageInspect struct {
+	ID           string    `json:"id"`
+	RepoTags     []string  `json:"repo_tags"`
+	RepoDigests  []string  `json:"repo_digests"`
+	Created      time.Time `json:"created"`
+	Size         int64     `json:"size"`
+	Architecture string    `json:"architecture"`
+	OS           string    `json:"os"`
+	Variant      string    `json:"variant,omitempty"`
+	Config       Config    `json:"config"`
+	RootFS       RootFS    `json:"rootfs"`
+	Layers       []string  `json:"layers"`
+}
+
+func Inspect(name string) (*ImageInspect, error) {
+	id, err := Resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := storage.LoadImageMetadata(id)
+	if err != nil {
+		return nil, err
+	}
+	config, err := GetImageConfig(id)
+	if err != nil {
+		return nil, err
+	}
+	m, err := loadManifest(id)
+	if err != nil {
+		return nil, err
+	}
+	store, err := storage.LoadReferenceStore()
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ImageInspect{
+		ID:           id,
+		RepoTags:     []string{},
+		RepoDigests:  []string{},
+		Created:      config.Created,
+		Size:         metadata.Size,
+		Architecture: config.Architecture,
+		OS:           config.OS,
+		Variant:      config.Variant,
+		Config:       config.Config,
+		RootFS:       config.RootFS,
+	}
+	for _, r := range store.References(id) {
+		ref, err := ParseReference(r)
+		if err != nil {
+			continue
+		}
+		if ref.Digest != "" {
+			info.RepoDigests = append(info.RepoDigests, ref.FamiliarName()+"@"+ref.Digest)
+		} else {
+			info.RepoTags = append(info.RepoTags, ref.FamiliarName()+":"+ref.Tag)
+		}
+	}
+	for _, layer := range m.Layers {
+		info.Layers = append(info.Layers, layer.Digest)
+	}
+	return info, nil
+}
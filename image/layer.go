@@ -0,0 +1,326 @@
+package image
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// OCI layer规范里的两种whiteout
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+	xattrPrefix    = "SCHILY.xattr."
+	//overlay的opaque目录用这个xattr标记
+	overlayOpaqueXattr = "trusted.overlay.opaque"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// decompress 看前几个字节判断压缩格式，没压缩的tar直接返回
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	buf := bufio.NewReader(r)
+	magic, err := buf.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(buf)
+	case bytes.HasPrefix(magic, zstdMagic):
+		return nil, fmt.Errorf("zstd compressed layer is not supported")
+	}
+	return io.NopCloser(buf), nil
+}
+
+// ApplyLayer 把一层的changeset应用到dest上，whiteout直接删掉下层的文件
+// 规则见 https://github.com/opencontainers/image-spec/blob/main/layer.md
+func ApplyLayer(r io.Reader, dest string) error {
+	return applyLayer(r, dest, false)
+}
+
+// ApplyLayerDiff 把一层单独解压到空目录，whiteout转成overlay的格式留给存储驱动处理
+func ApplyLayerDiff(r io.Reader, dest string) error {
+	return applyLayer(r, dest, true)
+}
+
+func applyLayer(r io.Reader, dest string, overlay bool) error {
+	rc, err := decompress(r)
+	if err != nil {
+		return fmt.Errorf("fail to decompress layer,%v", err)
+	}
+	defer rc.Close()
+
+	a := &layerApplier{
+		dest:    dest,
+		overlay: overlay,
+		written: map[string]bool{},
+	}
+	tr := tar.NewReader(rc)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("fail to read layer,%v", err)
+		}
+		if err := a.apply(header, tr); err != nil {
+			return fmt.Errorf("fail to apply %s,%w", header.Name, err)
+		}
+	}
+	return a.finish()
+}
+
+type layerApplier struct {
+	dest string
+	//true的时候whiteout写成0/0的字符设备，opaque写成xattr
+	overlay bool
+	//这一层自己写过的路径，opaque whiteout只删下层的东西
+	written map[string]bool
+	//目录的mtime要等里面的文件都写完才能设置
+	dirs []layerDir
+}
+
+type layerDir struct {
+	target string
+	header *tar.Header
+}
+
+func (a *layerApplier) apply(header *tar.Header, r io.Reader) error {
+	if err := checkName(header.Name); err != nil {
+		return err
+	}
+	name := path.Clean("/" + header.Name)
+	if name == "/" && header.Typeflag == tar.TypeDir {
+		a.dirs = append(a.dirs, layerDir{target: a.dest, header: header})
+		return a.setOwnerAndMode(a.dest, header)
+	}
+	dir, base := path.Split(name)
+
+	if base == whiteoutOpaque {
+		if a.overlay {
+			return a.overlayOpaque(dir)
+		}
+		return a.opaque(dir)
+	}
+	if strings.HasPrefix(base, whiteoutPrefix) {
+		target, err := resolveParent(a.dest, path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)))
+		if err != nil {
+			return err
+		}
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+		if a.overlay {
+			if err := os.MkdirAll(path.Dir(target), 0755); err != nil {
+				return err
+			}
+			return unix.Mknod(target, unix.S_IFCHR, 0)
+		}
+		return nil
+	}
+
+	//父目录里的软链接都在rootfs里解析，最后一级不跟随
+	target, err := resolveParent(a.dest, name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(target), 0755); err != nil {
+		return err
+	}
+	a.written[name] = true
+
+	//同名的旧文件先删掉，除非两边都是目录
+	if info, err := os.Lstat(target); err == nil {
+		if !(info.IsDir() && header.Typeflag == tar.TypeDir) {
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		if err := os.Mkdir(target, 0755); err != nil && !os.IsExist(err) {
+			return err
+		}
+		a.dirs = append(a.dirs, layerDir{target: target, header: header})
+	case tar.TypeReg, tar.TypeRegA:
+		//O_NOFOLLOW防止在上面Lstat之后又被换成软链接
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|unix.O_NOFOLLOW, 0600)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, r); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		//链接内容本身随便写，用的时候都在rootfs里解析
+		if err := os.Symlink(header.Linkname, target); err != nil {
+			return err
+		}
+	case tar.TypeLink:
+		if err := checkName(header.Linkname); err != nil {
+			return err
+		}
+		source, err := resolveParent(a.dest, header.Linkname)
+		if err != nil {
+			return err
+		}
+		if err := os.Link(source, target); err != nil {
+			return err
+		}
+		//硬链接和源文件共享inode，属性不用再设置
+		return nil
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		if err := mknod(target, header); err != nil {
+			return err
+		}
+	case tar.TypeXGlobalHeader:
+		return nil
+	default:
+		return fmt.Errorf("unsupported tar entry type %q", header.Typeflag)
+	}
+
+	if header.Typeflag == tar.TypeDir {
+		return a.setOwnerAndMode(target, header)
+	}
+	return a.setAttrs(target, header)
+}
+
+// opaque 清掉目录里下层带来的内容，本层已经写进去的保留
+func (a *layerApplier) opaque(dir string) error {
+	target, err := resolveInRoot(a.dest, dir)
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(target)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, e := range entries {
+		if a.written[path.Join(dir, e.Name())] {
+			continue
+		}
+		if err := os.RemoveAll(path.Join(target, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *layerApplier) overlayOpaque(dir string) error {
+	target, err := resolveInRoot(a.dest, dir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+	return unix.Lsetxattr(target, overlayOpaqueXattr, []byte("y"), 0)
+}
+
+func mknod(target string, header *tar.Header) error {
+	mode := uint32(header.Mode & 07777)
+	switch header.Typeflag {
+	case tar.TypeChar:
+		mode |= unix.S_IFCHR
+	case tar.TypeBlock:
+		mode |= unix.S_IFBLK
+	case tar.TypeFifo:
+		mode |= unix.S_IFIFO
+	}
+	dev := unix.Mkdev(uint32(header.Devmajor), uint32(header.Devminor))
+	return unix.Mknod(target, mode, int(dev))
+}
+
+// setOwnerAndMode chown会清掉setuid位，所以必须先chown再chmod
+func (a *layerApplier) setOwnerAndMode(target string, header *tar.Header) error {
+	if err := os.Lchown(target, header.Uid, header.Gid); err != nil {
+		return fmt.Errorf("fail to chown,%v", err)
+	}
+	for key, value := range header.PAXRecords {
+		name, ok := strings.CutPrefix(key, xattrPrefix)
+		if !ok {
+			continue
+		}
+		if err := unix.Lsetxattr(target, name, []byte(value), 0); err != nil && err != unix.ENOTSUP {
+			return fmt.Errorf("fail to set xattr %s,%v", name, err)
+		}
+	}
+	if header.Typeflag == tar.TypeSymlink {
+		return nil
+	}
+	return os.Chmod(target, tarMode(header))
+}
+
+func (a *layerApplier) setAttrs(target string, header *tar.Header) error {
+	if err := a.setOwnerAndMode(target, header); err != nil {
+		return err
+	}
+	return setTimes(target, header)
+}
+
+func (a *layerApplier) finish() error {
+	//倒着来，子目录先设置，不然改子目录会把父目录的mtime又改掉
+	for i := len(a.dirs) - 1; i >= 0; i-- {
+		if err := setTimes(a.dirs[i].target, a.dirs[i].header); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func tarMode(header *tar.Header) os.FileMode {
+	mode := os.FileMode(header.Mode & 0777)
+	if header.Mode&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if header.Mode&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if header.Mode&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+func setTimes(target string, header *tar.Header) error {
+	atime := header.AccessTime
+	if atime.IsZero() {
+		atime = header.ModTime
+	}
+	ts := []unix.Timespec{toTimespec(atime), toTimespec(header.ModTime)}
+	//软链接要用AT_SYMLINK_NOFOLLOW，不然改的是指向的文件
+	if err := unix.UtimesNanoAt(unix.AT_FDCWD, target, ts, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return fmt.Errorf("fail to set times,%v", err)
+	}
+	return nil
+}
+
+func toTimespec(t time.Time) unix.Timespec {
+	if t.IsZero() {
+		return unix.Timespec{Sec: 0, Nsec: unix.UTIME_OMIT}
+	}
+	return unix.NsecToTimespec(t.UnixNano())
+}
@@ -0,0 +1,327 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func applyLayers(t *testing.T, dest string, layers ...*bytes.Buffer) {
+	t.Helper()
+	for i, layer := range layers {
+		if err := ApplyLayer(layer, dest); err != nil {
+			t.Fatalf("fail to apply layer %d,%v", i, err)
+		}
+	}
+}
+
+// listDir 按名字排好序的目录内容
+func listDir(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func assertNames(t *testing.T, dir string, want ...string) {
+	t.Helper()
+	got := listDir(t, dir)
+	if len(got) != len(want) {
+		t.Fatalf("%s has %v, want %v", dir, got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("%s has %v, want %v", dir, got, want)
+		}
+	}
+}
+
+func requireRoot(t *testing.T) {
+	t.Helper()
+	if os.Getuid() != 0 {
+		t.Skip("need root")
+	}
+}
+
+func TestApplyLayerWhiteout(t *testing.T) {
+	root := t.TempDir()
+	lower := buildTar(t,
+		dir("etc/"),
+		file("etc/hosts", "hosts"),
+		file("etc/passwd", "passwd"),
+		dir("etc/ssl/"),
+		file("etc/ssl/cert.pem", "cert"),
+		dir("opt/"),
+		file("opt/old", "old"),
+		dir("opt/sub/"),
+		file("opt/sub/old", "old"),
+		dir("var/"),
+		file("var/old", "old"),
+	)
+	upper := buildTar(t,
+		file("etc/.wh.hosts", ""),
+		file("etc/.wh.ssl", ""),
+		//whiteout下层没有的文件不算错
+		file("etc/.wh.missing", ""),
+		dir("opt/"),
+		file("opt/.wh..wh..opq", ""),
+		file("opt/new", "new"),
+		//opaque在前面，本层后面写的也要留着
+		dir("var/"),
+		file("var/before", "before"),
+		file("var/.wh..wh..opq", ""),
+		dir("var/sub/"),
+		file("var/sub/after", "after"),
+	)
+	applyLayers(t, root, lower, upper)
+
+	assertNames(t, filepath.Join(root, "etc"), "passwd")
+	assertNames(t, filepath.Join(root, "opt"), "new")
+	assertNames(t, filepath.Join(root, "var"), "before", "sub")
+	assertNames(t, filepath.Join(root, "var/sub"), "after")
+
+	//whiteout之后上层还能再加回来
+	applyLayers(t, root, buildTar(t, file("etc/hosts", "new hosts")))
+	if b, err := os.ReadFile(filepath.Join(root, "etc/hosts")); err != nil || string(b) != "new hosts" {
+		t.Errorf("etc/hosts = %q,%v", b, err)
+	}
+}
+
+func TestApplyLayerReplace(t *testing.T) {
+	root := t.TempDir()
+	applyLayers(t, root,
+		buildTar(t, dir("a/"), file("a/x", "x"), file("b", "b"), symlink("c", "b")),
+		//上层同名的东西换了类型
+		buildTar(t, file("a", "now file"), dir("b/"), file("b/y", "y"), file("c", "now file")),
+	)
+	for name, want := range map[string]string{"a": "now file", "b/y": "y", "c": "now file"} {
+		b, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil || string(b) != want {
+			t.Errorf("%s = %q,%v, want %q", name, b, err, want)
+		}
+	}
+	if info, err := os.Lstat(filepath.Join(root, "c")); err != nil || !info.Mode().IsRegular() {
+		t.Errorf("c should be a regular file,%v", err)
+	}
+}
+
+func TestApplyLayerLinks(t *testing.T) {
+	root := t.TempDir()
+	applyLayers(t, root,
+		buildTar(t,
+			dir("usr/"),
+			dir("usr/bin/"),
+			file("usr/bin/busybox", "busybox"),
+			hardlink("usr/bin/sh", "usr/bin/busybox"),
+			//绝对路径的软链接可以建，只是解压的时候不跟随
+			symlink("usr/bin/ls", "/usr/bin/busybox"),
+			symlink("bin", "usr/bin"),
+			symlink("dangling", "does/not/exist"),
+		),
+		//上层给硬链接的源文件换了内容，硬链接本身也在上层
+		buildTar(t,
+			file("usr/bin/busybox", "busybox v2"),
+			hardlink("usr/bin/ash", "usr/bin/busybox"),
+		),
+	)
+
+	for name, want := range map[string]string{
+		"usr/bin/ls": "/usr/bin/busybox",
+		"bin":        "usr/bin",
+		"dangling":   "does/not/exist",
+	} {
+		got, err := os.Readlink(filepath.Join(root, name))
+		if err != nil || got != want {
+			t.Errorf("readlink %s = %q,%v, want %q", name, got, err, want)
+		}
+	}
+
+	busybox, err := os.Stat(filepath.Join(root, "usr/bin/busybox"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ash, err := os.Stat(filepath.Join(root, "usr/bin/ash"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(busybox, ash) {
+		t.Error("usr/bin/ash should be a hard link to usr/bin/busybox")
+	}
+	//下层的硬链接还指着旧的inode
+	sh, err := os.Stat(filepath.Join(root, "usr/bin/sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os.SameFile(busybox, sh) {
+		t.Error("usr/bin/sh should keep the old inode")
+	}
+	if b, _ := os.ReadFile(filepath.Join(root, "usr/bin/sh")); string(b) != "busybox" {
+		t.Errorf("usr/bin/sh = %q", b)
+	}
+}
+
+func TestApplyLayerDevices(t *testing.T) {
+	requireRoot(t)
+	root := t.TempDir()
+	applyLayers(t, root, buildTar(t,
+		dir("dev/"),
+		tarEntry{Header: tar.Header{Typeflag: tar.TypeChar, Name: "dev/null", Mode: 0666, Devmajor: 1, Devminor: 3}},
+		tarEntry{Header: tar.Header{Typeflag: tar.TypeBlock, Name: "dev/loop0", Mode: 0660, Devmajor: 7, Devminor: 0}},
+		tarEntry{Header: tar.Header{Typeflag: tar.TypeFifo, Name: "dev/fifo", Mode: 0600}},
+	))
+
+	tests := []struct {
+		name  string
+		typ   uint32
+		perm  uint32
+		major uint32
+		minor uint32
+	}{
+		{"dev/null", unix.S_IFCHR, 0666, 1, 3},
+		{"dev/loop0", unix.S_IFBLK, 0660, 7, 0},
+		{"dev/fifo", unix.S_IFIFO, 0600, 0, 0},
+	}
+	for _, tt := range tests {
+		var st unix.Stat_t
+		if err := unix.Lstat(filepath.Join(root, tt.name), &st); err != nil {
+			t.Errorf("%s,%v", tt.name, err)
+			continue
+		}
+		if st.Mode&unix.S_IFMT != tt.typ || st.Mode&07777 != tt.perm {
+			t.Errorf("%s mode %o, want %o", tt.name, st.Mode, tt.typ|tt.perm)
+		}
+		if tt.typ != unix.S_IFIFO && (unix.Major(st.Rdev) != tt.major || unix.Minor(st.Rdev) != tt.minor) {
+			t.Errorf("%s device %d:%d, want %d:%d", tt.name, unix.Major(st.Rdev), unix.Minor(st.Rdev), tt.major, tt.minor)
+		}
+	}
+}
+
+func TestApplyLayerAttrs(t *testing.T) {
+	requireRoot(t)
+	root := t.TempDir()
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	dirTime := time.Date(2019, 6, 7, 8, 9, 10, 0, time.UTC)
+	linkTime := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	applyLayers(t, root, buildTar(t,
+		tarEntry{Header: tar.Header{Typeflag: tar.TypeDir, Name: "home/", Mode: 0755, Uid: 1000, Gid: 1000, ModTime: dirTime}},
+		tarEntry{
+			Header: tar.Header{
+				Typeflag:   tar.TypeReg,
+				Name:       "home/app",
+				Mode:       04750,
+				Uid:        1000,
+				Gid:        2000,
+				ModTime:    mtime,
+				PAXRecords: map[string]string{xattrPrefix + "user.comment": "hello", xattrPrefix + "security.capability": "\x01\x00\x00\x02\x00\x04\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"},
+			},
+			Body: "app",
+		},
+		tarEntry{Header: tar.Header{Typeflag: tar.TypeSymlink, Name: "home/link", Linkname: "app", Uid: 1001, Gid: 1001, ModTime: linkTime}},
+	))
+
+	stat := func(name string) *syscall.Stat_t {
+		info, err := os.Lstat(filepath.Join(root, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.Sys().(*syscall.Stat_t)
+	}
+	app := stat("home/app")
+	if app.Uid != 1000 || app.Gid != 2000 {
+		t.Errorf("home/app owner %d:%d, want 1000:2000", app.Uid, app.Gid)
+	}
+	//chown在chmod前面，setuid位不会被清掉
+	if app.Mode&07777 != 04750 {
+		t.Errorf("home/app mode %o, want 4750", app.Mode&07777)
+	}
+	if got := time.Unix(app.Mtim.Unix()); !got.Equal(mtime) {
+		t.Errorf("home/app mtime %v, want %v", got, mtime)
+	}
+	link := stat("home/link")
+	if link.Uid != 1001 || link.Gid != 1001 {
+		t.Errorf("home/link owner %d:%d, want 1001:1001", link.Uid, link.Gid)
+	}
+	if got := time.Unix(link.Mtim.Unix()); !got.Equal(linkTime) {
+		t.Errorf("home/link mtime %v, want %v", got, linkTime)
+	}
+	//目录的mtime在里面的文件写完之后才设置
+	home := stat("home")
+	if home.Uid != 1000 || home.Gid != 1000 {
+		t.Errorf("home owner %d:%d, want 1000:1000", home.Uid, home.Gid)
+	}
+	if got := time.Unix(home.Mtim.Unix()); !got.Equal(dirTime) {
+		t.Errorf("home mtime %v, want %v", got, dirTime)
+	}
+
+	buf := make([]byte, 64)
+	n, err := unix.Lgetxattr(filepath.Join(root, "home/app"), "user.comment", buf)
+	if errors.Is(err, unix.ENOTSUP) {
+		t.Skip("xattr not supported")
+	}
+	if err != nil || string(buf[:n]) != "hello" {
+		t.Errorf("user.comment = %q,%v", buf[:n], err)
+	}
+	if _, err := unix.Lgetxattr(filepath.Join(root, "home/app"), "security.capability", buf); err != nil {
+		t.Errorf("security.capability,%v", err)
+	}
+}
+
+func TestApplyLayerDiffOverlay(t *testing.T) {
+	requireRoot(t)
+	root := t.TempDir()
+	if err := ApplyLayerDiff(buildTar(t,
+		dir("etc/"),
+		file("etc/.wh.hosts", ""),
+		dir("opt/"),
+		file("opt/.wh..wh..opq", ""),
+		file("opt/new", "new"),
+	), root); err != nil {
+		t.Fatal(err)
+	}
+	//whiteout变成0/0的字符设备
+	var st unix.Stat_t
+	if err := unix.Lstat(filepath.Join(root, "etc/hosts"), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFCHR || st.Rdev != 0 {
+		t.Errorf("etc/hosts mode %o rdev %d, want whiteout device", st.Mode, st.Rdev)
+	}
+	buf := make([]byte, 8)
+	n, err := unix.Lgetxattr(filepath.Join(root, "opt"), overlayOpaqueXattr, buf)
+	if err != nil || string(buf[:n]) != "y" {
+		t.Errorf("opt %s = %q,%v", overlayOpaqueXattr, buf[:n], err)
+	}
+	assertNames(t, filepath.Join(root, "opt"), "new")
+}
+
+func TestApplyLayerGzip(t *testing.T) {
+	root := t.TempDir()
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	if _, err := buildTar(t, file("hello", "world")).WriteTo(zw); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	applyLayers(t, root, buf)
+	if b, err := os.ReadFile(filepath.Join(root, "hello")); err != nil || string(b) != "world" {
+		t.Errorf("hello = %q,%v", b, err)
+	}
+}
@@ -2,72 +2,314 @@ package image
 
 import (
 	"docker/storage"
+	"encoding/json"
 	"fmt"
-	"io"
-	"log/slog"
 	"os"
 	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
 )
 
-func copy(src, dst string) error {
-	entry, err := os.ReadDir(src)
+// Check 本地没有就先拉，返回镜像ID
+func Check(name, platform string) (string, error) {
+	id, err := Resolve(name)
+	if err == nil {
+		return id, nil
+	}
+	return Pull(name, platform, os.Stdout)
+}
+
+// Layers 镜像每一层解压后的目录，从下到上，直接交给存储驱动
+func Layers(id string) ([]string, error) {
+	m, err := loadManifest(id)
 	if err != nil {
-		return fmt.Errorf("fail to read dir, %v", err)
+		return nil, err
 	}
 
-	for _, e := range entry {
-		srcPath := path.Join(src, e.Name())
-		dstPath := path.Join(dst, e.Name())
+	layers := make([]string, 0, len(m.Layers))
+	for _, layer := range m.Layers {
+		layerPath := storage.LayerPath(layer.Digest)
+		if _, err := os.Stat(path.Join(path.Dir(layerPath), "committed")); err != nil {
+			return nil, fmt.Errorf("layer %s is not extracted, pull the image again", ShortID(layer.Digest))
+		}
+		layers = append(layers, layerPath)
+	}
+	return layers, nil
+}
 
-		if e.IsDir() {
-			//递归地创建/复制
-			if err := os.MkdirAll(dstPath, 0755); err != nil {
-				return err
-			}
-			if err := copy(srcPath, dstPath); err != nil {
-				return err
-			}
-		} else {
-			srcFile, err := os.Open(srcPath)
-			if err != nil {
-				return fmt.Errorf("fail to open file, %v", err)
-			}
-			defer srcFile.Close()
+func loadManifest(id string) (*manifest, error) {
+	data, err := os.ReadFile(path.Join(storage.ImagePath(id), "manifest.json"))
+	if err != nil {
+		return nil, fmt.Errorf("fail to read manifest,%v", err)
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("fail to parse manifest,%v", err)
+	}
+	return &m, nil
+}
+
+// Resolve 镜像名、digest引用、完整ID或者ID前缀都可以，返回镜像ID
+func Resolve(name string) (string, error) {
+	store, err := storage.LoadReferenceStore()
+	if err != nil {
+		return "", err
+	}
+	if ref, err := ParseReference(name); err == nil {
+		if id, ok := store.Get(ref.Name(), ref.String()); ok {
+			return id, nil
+		}
+	}
 
-			dstFile, err := os.Create(dstPath)
+	prefix := strings.TrimPrefix(name, "sha256:")
+	if !hexRegexp.MatchString(prefix) {
+		return "", fmt.Errorf("no such image: %s", name)
+	}
+	ids, err := listImageIDs()
+	if err != nil {
+		return "", err
+	}
+	var match []string
+	for _, id := range ids {
+		if strings.HasPrefix(strings.TrimPrefix(id, "sha256:"), prefix) {
+			match = append(match, id)
+		}
+	}
+	switch len(match) {
+	case 0:
+		return "", fmt.Errorf("no such image: %s", name)
+	case 1:
+		return match[0], nil
+	}
+	return "", fmt.Errorf("image id prefix %s is ambiguous", name)
+}
+
+// listImageIDs 有metadata.json的才算拉完整了
+func listImageIDs() ([]string, error) {
+	entries, err := os.ReadDir(storage.ImageRoot)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ids []string
+	for _, e := range entries {
+		if !e.IsDir() || !hexRegexp.MatchString(e.Name()) {
+			continue
+		}
+		if _, err := os.Stat(path.Join(storage.ImageRoot, e.Name(), "metadata.json")); err != nil {
+			continue
+		}
+		ids = append(ids, "sha256:"+e.Name())
+	}
+	return ids, nil
+}
+
+type imageRow struct {
+	Repository string
+	Tag        string
+	Digest     string
+	Metadata   *storage.ImageMetadata
+}
+
+// listImages 每个tag一行，没有tag的镜像显示<none>
+func listImages() ([]*imageRow, error) {
+	store, err := storage.LoadReferenceStore()
+	if err != nil {
+		return nil, err
+	}
+	ids, err := listImageIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []*imageRow
+	for _, id := range ids {
+		metadata, err := storage.LoadImageMetadata(id)
+		if err != nil {
+			continue
+		}
+		tags := map[string][]string{}
+		digests := map[string]string{}
+		for _, r := range store.References(id) {
+			ref, err := ParseReference(r)
 			if err != nil {
-				return fmt.Errorf("fail to create file, %v", err)
+				continue
 			}
-			defer dstFile.Close()
-
-			if _, err := io.Copy(dstFile, srcFile); err != nil {
-				return fmt.Errorf("fail to copy file, %v", err)
+			if ref.Digest != "" {
+				digests[ref.FamiliarName()] = ref.Digest
+			} else {
+				tags[ref.FamiliarName()] = append(tags[ref.FamiliarName()], ref.Tag)
 			}
-			if err := dstFile.Chmod(0755); err != nil {
-				slog.Warn("fail to chmod file, %v", err)
+		}
+		for repo, digest := range digests {
+			if len(tags[repo]) == 0 {
+				rows = append(rows, &imageRow{Repository: repo, Tag: "<none>", Digest: digest, Metadata: metadata})
+			}
+		}
+		for repo, list := range tags {
+			for _, tag := range list {
+				rows = append(rows, &imageRow{Repository: repo, Tag: tag, Digest: digests[repo], Metadata: metadata})
 			}
 		}
+		if len(tags) == 0 && len(digests) == 0 {
+			rows = append(rows, &imageRow{Repository: "<none>", Tag: "<none>", Metadata: metadata})
+		}
 	}
-	return nil
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Repository != rows[j].Repository {
+			return rows[i].Repository < rows[j].Repository
+		}
+		return rows[i].Tag < rows[j].Tag
+	})
+	return rows, nil
 }
 
-func Check(name, containerID string) (string, error) {
-	imagePath := path.Join(storage.ImageRoot, name)
+func ListImages() error {
+	rows, err := listImages()
+	if err != nil {
+		return err
+	}
 
-	if _, err := os.Stat(imagePath); os.IsNotExist(err) {
-		if err := Pull(name); err != nil {
-			return "", err
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "REPOSITORY\tTAG\tDIGEST\tIMAGE ID\tCREATED\tSIZE")
+	for _, r := range rows {
+		created := "N/A"
+		if !r.Metadata.Created.IsZero() {
+			created = r.Metadata.Created.Format(time.DateTime)
+		}
+		digest := r.Digest
+		if digest == "" {
+			digest = "<none>"
 		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Repository, r.Tag, digest, ShortID(r.Metadata.ID), created, storage.HumanSize(r.Metadata.Size))
 	}
+	return w.Flush()
+}
 
-	containerRootfs := path.Join(storage.ContainRoot, containerID, "rootfs")
-	if err := os.MkdirAll(containerRootfs, 0755); err != nil {
-		return "", err
+// Tag 只是在repositories.json里加一个引用，内容不复制
+func Tag(source, target string) error {
+	id, err := Resolve(source)
+	if err != nil {
+		return err
+	}
+	ref, err := ParseReference(target)
+	if err != nil {
+		return err
+	}
+	if ref.Digest != "" {
+		return fmt.Errorf("refusing to create a tag with a digest reference")
 	}
+	return storage.UpdateReferenceStore(func(store *storage.ReferenceStore) error {
+		store.Add(ref.Name(), ref.String(), id)
+		return nil
+	})
+}
 
-	imageRootfs := path.Join(imagePath, "rootfs")
-	if err := copy(imageRootfs, containerRootfs); err != nil {
-		return "", err
+// Remove 按名字删只去掉这个tag，没有别的tag了才真正删镜像
+// 按ID删的时候还有多个tag要force
+func Remove(name string, force bool) error {
+	id, err := Resolve(name)
+	if err != nil {
+		return err
 	}
-	return imagePath, nil
+
+	deleted := false
+	err = storage.UpdateReferenceStore(func(store *storage.ReferenceStore) error {
+		byRef := false
+		if ref, err := ParseReference(name); err == nil {
+			if store.Delete(ref.Name(), ref.String()) {
+				byRef = true
+				fmt.Printf("Untagged: %s\n", name)
+			}
+		}
+
+		var tags []string
+		for _, r := range store.References(id) {
+			if ref, err := ParseReference(r); err == nil && ref.Digest == "" {
+				tags = append(tags, r)
+			}
+		}
+		if byRef && len(tags) > 0 {
+			return nil
+		}
+		if !byRef && len(tags) > 1 && !force {
+			return fmt.Errorf("image %s is referenced in multiple repositories, use --force to remove", ShortID(id))
+		}
+
+		for _, r := range store.References(id) {
+			ref, err := ParseReference(r)
+			if err != nil {
+				continue
+			}
+			store.Delete(ref.Name(), r)
+			fmt.Printf("Untagged: %s\n", r)
+		}
+		deleted = true
+		return nil
+	})
+	if err != nil || !deleted {
+		return err
+	}
+
+	imagePath := storage.ImagePath(id)
+	lock, err := storage.Lock(imagePath + ".lock")
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	digests, _ := imageBlobs(id)
+	if err := os.RemoveAll(imagePath); err != nil {
+		return fmt.Errorf("fail to remove image,%v", err)
+	}
+	fmt.Printf("Deleted: %s\n", id)
+	return removeBlobs(digests)
+}
+
+// imageBlobs 镜像用到的config和每一层的digest
+func imageBlobs(id string) ([]string, error) {
+	data, err := os.ReadFile(path.Join(storage.ImagePath(id), "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	digests := []string{m.Config.Digest}
+	for _, layer := range m.Layers {
+		digests = append(digests, layer.Digest)
+	}
+	return digests, nil
+}
+
+// removeBlobs 别的镜像没用到的blob和解压出来的层才删，有容器在用的rmi之前已经拦住了
+func removeBlobs(digests []string) error {
+	ids, err := listImageIDs()
+	if err != nil {
+		return err
+	}
+	used := map[string]bool{}
+	for _, id := range ids {
+		list, _ := imageBlobs(id)
+		for _, d := range list {
+			used[d] = true
+		}
+	}
+	blobs := storage.NewBlobStore(storage.BlobRoot)
+	for _, d := range digests {
+		if used[d] {
+			continue
+		}
+		if err := blobs.Delete(d); err != nil {
+			return fmt.Errorf("fail to delete blob %s,%v", ShortID(d), err)
+		}
+		if err := removeLayer(d); err != nil {
+			return fmt.Errorf("fail to delete layer %s,%v", ShortID(d), err)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,110 @@
+package image
+
+import (
+	"bytes"
+	"docker/storage"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+// useTempStorage 镜像、blob、层和引用都放到临时目录
+func useTempStorage(t *testing.T) {
+	t.Helper()
+	root := t.TempDir()
+	oldImage, oldBlob, oldLayer, oldRepo := storage.ImageRoot, storage.BlobRoot, storage.LayerRoot, storage.RepositoriesFile
+	storage.ImageRoot = filepath.Join(root, "images")
+	storage.BlobRoot = filepath.Join(root, "blobs")
+	storage.LayerRoot = filepath.Join(root, "layers")
+	storage.RepositoriesFile = filepath.Join(storage.ImageRoot, "repositories.json")
+	t.Cleanup(func() {
+		storage.ImageRoot, storage.BlobRoot, storage.LayerRoot, storage.RepositoriesFile = oldImage, oldBlob, oldLayer, oldRepo
+	})
+}
+
+// saveTestImage 和pull完的样子一样：blob、解压好的层、manifest、metadata和tag都有
+func saveTestImage(t *testing.T, tag string, layers ...string) string {
+	t.Helper()
+	blobs := storage.NewBlobStore(storage.BlobRoot)
+	ingest := func(data []byte) string {
+		digest := blobDigest(data)
+		if err := blobs.Ingest(digest, int64(len(data)), bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+		return digest
+	}
+	config := []byte(`{"architecture":"amd64","os":"linux","tag":"` + tag + `"}`)
+	m := &manifest{Config: descriptor{Digest: ingest(config), Size: int64(len(config))}}
+	for _, layer := range layers {
+		digest := ingest([]byte(layer))
+		m.Layers = append(m.Layers, descriptor{Digest: digest, Size: int64(len(layer))})
+		if err := ExtractLayer(blobs, digest); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	id := m.Config.Digest
+	if err := storage.SaveImageMetadata(&storage.ImageMetadata{ID: id, Name: tag}); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveManifest(m, storage.ImagePath(id)); err != nil {
+		t.Fatal(err)
+	}
+	ref, err := ParseReference(tag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = storage.UpdateReferenceStore(func(store *storage.ReferenceStore) error {
+		store.Add(ref.Name(), ref.String(), id)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func assertLayer(t *testing.T, layer string, exists bool) {
+	t.Helper()
+	digest := blobDigest([]byte(layer))
+	layerDir := path.Dir(storage.LayerPath(digest))
+	for _, p := range []string{layerDir, path.Join(layerDir, "committed"), layerDir + ".lock"} {
+		_, err := os.Stat(p)
+		if exists && err != nil {
+			t.Errorf("%s should be kept,%v", p, err)
+		}
+		if !exists && !os.IsNotExist(err) {
+			t.Errorf("%s should be removed,%v", p, err)
+		}
+	}
+	if got := storage.NewBlobStore(storage.BlobRoot).Has(digest); got != exists {
+		t.Errorf("blob %s exists %v, want %v", ShortID(digest), got, exists)
+	}
+}
+
+// rmi之后别的镜像还在用的层留着，没人用的连解压出来的目录一起删
+func TestRemoveLayers(t *testing.T) {
+	useTempStorage(t)
+	shared := buildTar(t, file("bin/sh", "sh")).String()
+	only1 := buildTar(t, file("v1", "1")).String()
+	only2 := buildTar(t, file("v2", "2")).String()
+	first := saveTestImage(t, "app:v1", shared, only1)
+	saveTestImage(t, "app:v2", shared, only2)
+
+	if err := Remove("app:v1", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(storage.ImagePath(first)); !os.IsNotExist(err) {
+		t.Errorf("image %s should be removed,%v", first, err)
+	}
+	assertLayer(t, shared, true)
+	assertLayer(t, only1, false)
+	assertLayer(t, only2, true)
+
+	if err := Remove("app:v2", false); err != nil {
+		t.Fatal(err)
+	}
+	assertLayer(t, shared, false)
+	assertLayer(t, only2, false)
+}
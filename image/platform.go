@@ -0,0 +1,97 @@
+package image
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+func (p Platform) String() string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// DefaultPlatform 容器只能跑在linux上，所以os固定写linux
+func DefaultPlatform() Platform {
+	p := Platform{
+		OS:           "linux",
+		Architecture: runtime.GOARCH,
+	}
+	switch p.Architecture {
+	case "arm64":
+		p.Variant = "v8"
+	case "arm":
+		p.Variant = "v7"
+	}
+	return p
+}
+
+// ParsePlatform 解析 os/arch[/variant]，空字符串返回本机的
+func ParsePlatform(s string) (Platform, error) {
+	if s == "" {
+		return DefaultPlatform(), nil
+	}
+	part := strings.Split(strings.ToLower(s), "/")
+	if len(part) < 2 || len(part) > 3 || part[0] == "" || part[1] == "" {
+		return Platform{}, fmt.Errorf("invalid platform %q, want os/arch[/variant]", s)
+	}
+	p := Platform{OS: part[0], Architecture: part[1]}
+	if len(part) == 3 {
+		p.Variant = part[2]
+	}
+	//docker里常见的几个别名
+	switch p.Architecture {
+	case "x86_64", "x86-64":
+		p.Architecture = "amd64"
+	case "aarch64":
+		p.Architecture = "arm64"
+	}
+	if p.Architecture == "arm64" && p.Variant == "" {
+		p.Variant = "v8"
+	}
+	return p, nil
+}
+
+func variantMatch(want, got Platform) bool {
+	if want.Variant == got.Variant {
+		return true
+	}
+	//arm64不写variant默认就是v8
+	if want.Architecture == "arm64" {
+		return (want.Variant == "v8" && got.Variant == "") || (want.Variant == "" && got.Variant == "v8")
+	}
+	return want.Variant == ""
+}
+
+// selectManifest 在manifest list/index里挑出和平台匹配的那一项
+func selectManifest(list []descriptor, want Platform) (*descriptor, error) {
+	var candidate *descriptor
+	for i := range list {
+		d := &list[i]
+		if d.Platform == nil {
+			continue
+		}
+		if d.Platform.OS != want.OS || d.Platform.Architecture != want.Architecture {
+			continue
+		}
+		if d.Platform.Variant == want.Variant {
+			return d, nil
+		}
+		if candidate == nil && variantMatch(want, *d.Platform) {
+			candidate = d
+		}
+	}
+	if candidate == nil {
+		return nil, fmt.Errorf("no matching manifest for %s in the manifest list", want)
+	}
+	return candidate, nil
+}
@@ -0,0 +1,103 @@
+package image
+
+import (
+	"docker/storage"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// progress 每一层一行，终端里原地刷新，不是终端就只打状态变化
+type progress struct {
+	mu     sync.Mutex
+	out    io.Writer
+	tty    bool
+	ids    []string
+	lines  map[string]string
+	drawn  int
+	status map[string]string
+}
+
+func newProgress(out io.Writer) *progress {
+	p := &progress{
+		out:    out,
+		lines:  map[string]string{},
+		status: map[string]string{},
+	}
+	if f, ok := out.(*os.File); ok {
+		_, err := unix.IoctlGetTermios(int(f.Fd()), unix.TCGETS)
+		p.tty = err == nil
+	}
+	return p
+}
+
+// update status是阶段（Downloading/Pull complete），detail是进度
+func (p *progress) update(id, status, detail string) {
+	if p == nil || p.out == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.lines[id]; !ok {
+		p.ids = append(p.ids, id)
+	}
+	line := fmt.Sprintf("%s: %s", id, status)
+	if detail != "" {
+		line += " " + detail
+	}
+	p.lines[id] = line
+
+	if !p.tty {
+		if p.status[id] != status {
+			p.status[id] = status
+			fmt.Fprintf(p.out, "%s: %s\n", id, status)
+		}
+		return
+	}
+	p.status[id] = status
+	//光标回到第一行，全部重画一遍
+	if p.drawn > 0 {
+		fmt.Fprintf(p.out, "\033[%dA", p.drawn)
+	}
+	for _, id := range p.ids {
+		fmt.Fprintf(p.out, "\033[2K%s\n", p.lines[id])
+	}
+	p.drawn = len(p.ids)
+}
+
+func (p *progress) printf(format string, args ...any) {
+	if p == nil || p.out == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprintf(p.out, format, args...)
+	//后面的输出不能再被当成进度行覆盖掉
+	p.ids = nil
+	p.drawn = 0
+}
+
+// progressReader 下载的时候顺便报进度，100ms刷新一次
+type progressReader struct {
+	r       io.Reader
+	p       *progress
+	id      string
+	total   int64
+	current int64
+	last    time.Time
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.current += int64(n)
+	if time.Since(r.last) > 100*time.Millisecond || err == io.EOF {
+		r.last = time.Now()
+		r.p.update(r.id, "Downloading", fmt.Sprintf("%s/%s", storage.HumanSize(r.current), storage.HumanSize(r.total)))
+	}
+	return n, err
+}
@@ -0,0 +1,104 @@
+package image
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const defaultTag = "latest"
+
+var (
+	digestRegexp = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+	repoRegexp   = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+	tagRegexp    = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	hexRegexp    = regexp.MustCompile(`^[a-f0-9]+$`)
+)
+
+// Reference 规范化之后的镜像名，registry/repository:tag 或者 registry/repository@digest
+type Reference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseReference 规则和docker一样：
+// 第一段带.或:或者是localhost才算registry，不然就是docker.io
+// docker.io下面没有/的自动加library/，没写tag的默认latest
+func ParseReference(s string) (*Reference, error) {
+	ref := &Reference{}
+	name := s
+	if before, digest, ok := strings.Cut(s, "@"); ok {
+		if !digestRegexp.MatchString(digest) {
+			return nil, fmt.Errorf("invalid digest in reference %q", s)
+		}
+		name = before
+		ref.Digest = digest
+	}
+
+	//tag里的:一定在最后一个/后面，不然就是registry的端口
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		ref.Tag = name[i+1:]
+		name = name[:i]
+		if !tagRegexp.MatchString(ref.Tag) {
+			return nil, fmt.Errorf("invalid tag in reference %q", s)
+		}
+	}
+
+	first, rest, ok := strings.Cut(name, "/")
+	if ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		ref.Registry = normalizeRegistry(first)
+		ref.Repository = rest
+	} else {
+		ref.Registry = defaultRegistry
+		ref.Repository = name
+	}
+	if ref.Registry == defaultRegistry && !strings.Contains(ref.Repository, "/") {
+		ref.Repository = "library/" + ref.Repository
+	}
+	if !repoRegexp.MatchString(ref.Repository) {
+		return nil, fmt.Errorf("invalid reference format %q, repository name must be lowercase", s)
+	}
+
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = defaultTag
+	}
+	return ref, nil
+}
+
+// Name registry/repository，也就是repositories.json里的key
+func (r *Reference) Name() string {
+	return r.Registry + "/" + r.Repository
+}
+
+func (r *Reference) String() string {
+	if r.Digest != "" {
+		return r.Name() + "@" + r.Digest
+	}
+	return r.Name() + ":" + r.Tag
+}
+
+// FamiliarName 显示用的短名字，docker.io/library/busybox -> busybox
+func (r *Reference) FamiliarName() string {
+	if r.Registry != defaultRegistry {
+		return r.Name()
+	}
+	return strings.TrimPrefix(r.Repository, "library/")
+}
+
+// manifestReference 拉manifest的时候用的，有digest优先用digest
+func (r *Reference) manifestReference() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+func ShortID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
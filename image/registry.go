@@ -0,0 +1,263 @@
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// docker hub的域名和真正提供v2接口的域名不是同一个
+const (
+	defaultRegistry  = "docker.io"
+	defaultEndpoint  = "registry-1.docker.io"
+	registryUA       = "easydocker/1.0"
+	challengeBearer  = "bearer"
+	challengeBasic   = "basic"
+	authHeader       = "Authorization"
+	challengeHeader  = "WWW-Authenticate"
+	tokenScopeFormat = "repository:%s:pull"
+)
+
+// HTTPClient 拉镜像用的client，测试的时候可以换成httptest的
+var HTTPClient = http.DefaultClient
+
+type challenge struct {
+	Scheme string
+	Params map[string]string
+}
+
+type tokenResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+}
+
+type registryClient struct {
+	client   *http.Client
+	scheme   string
+	registry string
+	endpoint string
+	repo     string
+	auth     *AuthEntry
+	//多个layer并发下载，authorization要加锁
+	mu sync.Mutex
+	//bearer token或者basic凭证，拿到之后每个请求都带上
+	authorization string
+}
+
+func newRegistryClient(registry, repo string) (*registryClient, error) {
+	auth, err := GetAuth(registry)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := registry
+	if registry == defaultRegistry {
+		endpoint = defaultEndpoint
+	}
+	return &registryClient{
+		client:   HTTPClient,
+		scheme:   registryScheme(endpoint),
+		registry: registry,
+		endpoint: endpoint,
+		repo:     repo,
+		auth:     auth,
+	}, nil
+}
+
+// 和docker一样，本地的registry默认走http
+func registryScheme(endpoint string) string {
+	host := endpoint
+	if h, _, err := net.SplitHostPort(endpoint); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return "http"
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return "http"
+	}
+	return "https"
+}
+
+func (r *registryClient) url(format string, args ...any) string {
+	return fmt.Sprintf("%s://%s", r.scheme, r.endpoint) + fmt.Sprintf(format, args...)
+}
+
+// get 遇到401就按WWW-Authenticate的要求去拿凭证，然后重试一次
+func (r *registryClient) get(url string, header http.Header) (*http.Response, error) {
+	return r.getWithContext(context.Background(), url, header)
+}
+
+// getWithContext ctx取消的时候请求和还没读完的body都会断掉
+func (r *registryClient) getWithContext(ctx context.Context, url string, header http.Header) (*http.Response, error) {
+	res, err := r.do(ctx, url, header)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusUnauthorized {
+		return res, nil
+	}
+	value := res.Header.Get(challengeHeader)
+	res.Body.Close()
+
+	r.mu.Lock()
+	err = r.authorize(ctx, value)
+	r.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return r.do(ctx, url, header)
+}
+
+func (r *registryClient) do(ctx context.Context, url string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("User-Agent", registryUA)
+	r.mu.Lock()
+	if r.authorization != "" {
+		req.Header.Set(authHeader, r.authorization)
+	}
+	r.mu.Unlock()
+	return r.client.Do(req)
+}
+
+// authorize 拿token的请求也跟着ctx，下载取消的时候不会卡在token服务上
+func (r *registryClient) authorize(ctx context.Context, value string) error {
+	c, err := parseChallenge(value)
+	if err != nil {
+		return err
+	}
+	switch c.Scheme {
+	case challengeBasic:
+		if r.auth == nil {
+			return fmt.Errorf("registry %s requires login", r.registry)
+		}
+		r.authorization = "Basic " + r.auth.Auth
+		return nil
+	case challengeBearer:
+		token, err := r.fetchToken(ctx, c)
+		if err != nil {
+			return err
+		}
+		r.authorization = "Bearer " + token
+		return nil
+	}
+	return fmt.Errorf("unsupported auth scheme %s", c.Scheme)
+}
+
+func (r *registryClient) fetchToken(ctx context.Context, c *challenge) (string, error) {
+	realm := c.Params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("empty realm in challenge")
+	}
+	u, err := url.Parse(realm)
+	if err != nil {
+		return "", fmt.Errorf("fail to parse realm,%v", err)
+	}
+	query := u.Query()
+	if service := c.Params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	//login的时候没有仓库，只校验账号密码
+	if scope := c.Params["scope"]; scope != "" {
+		query.Set("scope", scope)
+	} else if r.repo != "" {
+		query.Set("scope", fmt.Sprintf(tokenScopeFormat, r.repo))
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", registryUA)
+	if r.auth != nil {
+		req.Header.Set(authHeader, "Basic "+r.auth.Auth)
+	}
+	res, err := r.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("fail to get token,%v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return "", fmt.Errorf("fail to get token,status=%d,%s", res.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var t tokenResponse
+	if err := json.NewDecoder(res.Body).Decode(&t); err != nil {
+		return "", fmt.Errorf("fail to parse token,%v", err)
+	}
+	if t.Token != "" {
+		return t.Token, nil
+	}
+	if t.AccessToken != "" {
+		return t.AccessToken, nil
+	}
+	return "", fmt.Errorf("empty token")
+}
+
+// parseChallenge 解析 Bearer realm="...",service="...",scope="..."
+// scope里面可能有逗号(pull,push)，所以不能直接按逗号切
+func parseChallenge(value string) (*challenge, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, fmt.Errorf("empty challenge")
+	}
+	scheme, rest, _ := strings.Cut(value, " ")
+	c := &challenge{
+		Scheme: strings.ToLower(scheme),
+		Params: map[string]string{},
+	}
+
+	for {
+		rest = strings.TrimLeft(rest, " ,")
+		if rest == "" {
+			break
+		}
+		key, after, ok := strings.Cut(rest, "=")
+		if !ok {
+			return nil, fmt.Errorf("bad challenge: %s", value)
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+
+		var val string
+		if strings.HasPrefix(after, `"`) {
+			end := strings.Index(after[1:], `"`)
+			if end < 0 {
+				return nil, fmt.Errorf("bad challenge: %s", value)
+			}
+			val = after[1 : end+1]
+			rest = after[end+2:]
+		} else {
+			val, rest, _ = strings.Cut(after, ",")
+			val = strings.TrimSpace(val)
+		}
+		c.Params[key] = val
+	}
+	return c, nil
+}
+
+// ping 用/v2/检查凭证是否可用
+func (r *registryClient) ping() error {
+	res, err := r.get(r.url("/v2/"), nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("fail to login %s,status=%d", r.registry, res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package image
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// 和linux的MAXSYMLINKS一样
+const maxSymlinks = 255
+
+// BreakoutError 层里的路径想跑到rootfs外面去
+type BreakoutError struct {
+	Name   string
+	Reason string
+}
+
+func (e *BreakoutError) Error() string {
+	return fmt.Sprintf("path %q escapes rootfs: %s", e.Name, e.Reason)
+}
+
+// checkName tar里的名字按相对rootfs处理，..跑出去的直接拒绝
+func checkName(name string) error {
+	clean := path.Clean(name)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return &BreakoutError{Name: name, Reason: "parent directory reference"}
+	}
+	return nil
+}
+
+// resolveInRoot 效果和openat2(RESOLVE_IN_ROOT)一样：
+// 把root当成/，路径里的软链接和..都在root里面解析，最后的结果一定在root下面
+// 不存在的部分原样拼上去，方便后面创建
+func resolveInRoot(root, unsafePath string) (string, error) {
+	current := ""
+	remaining := path.Clean("/" + unsafePath)
+	links := 0
+
+	for remaining != "" && remaining != "/" {
+		remaining = strings.TrimPrefix(remaining, "/")
+		part, rest, _ := strings.Cut(remaining, "/")
+		remaining = rest
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			//到了root就停在root，不会再往上
+			current = path.Dir("/" + current)
+			current = strings.TrimPrefix(current, "/")
+			continue
+		}
+
+		next := path.Join(current, part)
+		info, err := os.Lstat(path.Join(root, next))
+		if err != nil {
+			if os.IsNotExist(err) {
+				current = next
+				continue
+			}
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			current = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", &BreakoutError{Name: unsafePath, Reason: "too many levels of symbolic links"}
+		}
+		target, err := os.Readlink(path.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		//绝对路径的链接从root重新开始，相对的从链接所在目录开始
+		if path.IsAbs(target) {
+			current = ""
+		}
+		remaining = target + "/" + remaining
+	}
+	return path.Join(root, current), nil
+}
+
+// resolveParent 只解析父目录，最后一级不跟随软链接
+// 因为要创建/替换的就是最后一级本身
+func resolveParent(root, name string) (string, error) {
+	clean := path.Clean("/" + name)
+	dir, base := path.Split(clean)
+	parent, err := resolveInRoot(root, dir)
+	if err != nil {
+		return "", err
+	}
+	if base == "" {
+		return parent, nil
+	}
+	return path.Join(parent, base), nil
+}
@@ -0,0 +1,428 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// tarEntry 测试用的tar条目，Body只有普通文件用
+type tarEntry struct {
+	tar.Header
+	Body string
+}
+
+func dir(name string) tarEntry {
+	return tarEntry{Header: tar.Header{Typeflag: tar.TypeDir, Name: name, Mode: 0755}}
+}
+
+func file(name, body string) tarEntry {
+	return tarEntry{Header: tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644}, Body: body}
+}
+
+func symlink(name, target string) tarEntry {
+	return tarEntry{Header: tar.Header{Typeflag: tar.TypeSymlink, Name: name, Linkname: target, Mode: 0777}}
+}
+
+func hardlink(name, target string) tarEntry {
+	return tarEntry{Header: tar.Header{Typeflag: tar.TypeLink, Name: name, Linkname: target}}
+}
+
+// buildTar 在内存里拼一个层，uid/gid没写的用当前用户，非root也能chown
+func buildTar(t *testing.T, entries ...tarEntry) *bytes.Buffer {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, e := range entries {
+		h := e.Header
+		if h.Uid == 0 && h.Gid == 0 {
+			h.Uid, h.Gid = os.Getuid(), os.Getgid()
+		}
+		if h.Typeflag == tar.TypeReg {
+			h.Size = int64(len(e.Body))
+		}
+		if h.Format == tar.FormatUnknown && len(h.PAXRecords) > 0 {
+			h.Format = tar.FormatPAX
+		}
+		if err := tw.WriteHeader(&h); err != nil {
+			t.Fatalf("fail to write header %s,%v", h.Name, err)
+		}
+		if e.Body != "" {
+			if _, err := tw.Write([]byte(e.Body)); err != nil {
+				t.Fatalf("fail to write %s,%v", h.Name, err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+// sandbox rootfs放在base/a/b/rootfs，真跑出去了也还落在base里面，方便检查
+type sandbox struct {
+	base   string
+	root   string
+	before map[string]bool
+}
+
+func newSandbox(t *testing.T) *sandbox {
+	base := t.TempDir()
+	root := filepath.Join(base, "a", "b", "rootfs")
+	if err := os.MkdirAll(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	//给硬链接用的宿主机文件
+	if err := os.WriteFile(filepath.Join(base, "a", "b", "secret"), []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s := &sandbox{base: base, root: root}
+	s.before = s.outside(t)
+	return s
+}
+
+// outside rootfs外面的所有路径
+func (s *sandbox) outside(t *testing.T) map[string]bool {
+	t.Helper()
+	paths := map[string]bool{}
+	err := filepath.WalkDir(s.base, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == s.root {
+			return filepath.SkipDir
+		}
+		paths[p] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+func (s *sandbox) assertNothingOutside(t *testing.T) {
+	t.Helper()
+	for p := range s.outside(t) {
+		if !s.before[p] {
+			t.Errorf("%s was written outside rootfs", p)
+		}
+	}
+	info, err := os.Stat(filepath.Join(s.base, "a", "b", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := info.Sys().(*syscall.Stat_t).Nlink; n != 1 {
+		t.Errorf("host file got hard linked, nlink %d", n)
+	}
+}
+
+func assertBreakout(t *testing.T, err error) {
+	t.Helper()
+	var breakout *BreakoutError
+	if !errors.As(err, &breakout) {
+		t.Fatalf("expect *BreakoutError, got %v", err)
+	}
+}
+
+func TestApplyLayerBreakout(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+	}{
+		{
+			name:    "parent directory entry",
+			entries: []tarEntry{file("../../etc/x", "pwned")},
+		},
+		{
+			name:    "parent directory in the middle",
+			entries: []tarEntry{dir("usr/"), file("usr/../../../etc/x", "pwned")},
+		},
+		{
+			name:    "hardlink to parent directory",
+			entries: []tarEntry{hardlink("stolen", "../secret")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSandbox(t)
+			err := ApplyLayer(buildTar(t, tt.entries...), s.root)
+			assertBreakout(t, err)
+			s.assertNothingOutside(t)
+		})
+	}
+}
+
+// 绝对路径和软链接里的..都按rootfs是/来解析，写进去的东西要落在rootfs里面
+func TestApplyLayerStaysInRoot(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries func(s *sandbox) []tarEntry
+		want    func(s *sandbox) string
+		gone    string
+	}{
+		{
+			name: "absolute path",
+			entries: func(s *sandbox) []tarEntry {
+				return []tarEntry{file(filepath.Join(s.base, "x"), "data")}
+			},
+			want: func(s *sandbox) string { return filepath.Join(s.base, "x") },
+		},
+		{
+			name: "write through symlink to /",
+			entries: func(s *sandbox) []tarEntry {
+				return []tarEntry{
+					symlink("host", "/"),
+					file("host"+filepath.Join(s.base, "x"), "data"),
+				}
+			},
+			want: func(s *sandbox) string { return filepath.Join(s.base, "x") },
+		},
+		{
+			name: "write through symlink to ..",
+			entries: func(s *sandbox) []tarEntry {
+				return []tarEntry{
+					symlink("up", ".."),
+					file("up/x", "data"),
+				}
+			},
+			want: func(s *sandbox) string { return "x" },
+		},
+		{
+			name: "directory through symlink to ..",
+			entries: func(s *sandbox) []tarEntry {
+				return []tarEntry{
+					symlink("up", "../.."),
+					dir("up/etc/"),
+				}
+			},
+			want: func(s *sandbox) string { return "etc" },
+		},
+		{
+			name: "hardlink through symlink",
+			entries: func(s *sandbox) []tarEntry {
+				return []tarEntry{
+					file("secret", "data"),
+					symlink("up", ".."),
+					hardlink("stolen", "up/secret"),
+				}
+			},
+			want: func(s *sandbox) string { return "stolen" },
+		},
+		{
+			name: "nested symlink chain",
+			entries: func(s *sandbox) []tarEntry {
+				//每个链接都停在rootfs的/，连起来也一样
+				return []tarEntry{
+					dir("d/"),
+					symlink("d/l1", ".."),
+					symlink("l2", "d/l1/.."),
+					file("l2/x", "data"),
+				}
+			},
+			want: func(s *sandbox) string { return "x" },
+		},
+		{
+			name: "nested symlink chain to absolute",
+			entries: func(s *sandbox) []tarEntry {
+				return []tarEntry{
+					dir("d/"),
+					symlink("d/l1", "/"),
+					symlink("l2", "d/l1"),
+					file("l2"+filepath.Join(s.base, "x"), "data"),
+				}
+			},
+			want: func(s *sandbox) string { return filepath.Join(s.base, "x") },
+		},
+		{
+			name: "whiteout through symlink",
+			entries: func(s *sandbox) []tarEntry {
+				return []tarEntry{
+					file("secret", "data"),
+					symlink("up", ".."),
+					file("up/.wh.secret", ""),
+				}
+			},
+			gone: "secret",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSandbox(t)
+			if err := ApplyLayer(buildTar(t, tt.entries(s)...), s.root); err != nil {
+				t.Fatal(err)
+			}
+			s.assertNothingOutside(t)
+			if tt.want != nil {
+				if _, err := os.Lstat(filepath.Join(s.root, tt.want(s))); err != nil {
+					t.Errorf("%s not extracted in rootfs,%v", tt.want(s), err)
+				}
+			}
+			if tt.gone != "" {
+				if _, err := os.Lstat(filepath.Join(s.root, tt.gone)); !os.IsNotExist(err) {
+					t.Errorf("%s should be removed,%v", tt.gone, err)
+				}
+			}
+		})
+	}
+}
+
+// 硬链接的绝对路径也是相对rootfs的，rootfs里没有这个文件就只是链接失败，不会链到宿主机上
+func TestApplyLayerHardlinkAbsolute(t *testing.T) {
+	s := newSandbox(t)
+	layer := buildTar(t, hardlink("stolen", filepath.Join(s.base, "a", "b", "secret")))
+	err := ApplyLayer(layer, s.root)
+	if err == nil {
+		t.Fatal("expect link error")
+	}
+	var breakout *BreakoutError
+	if errors.As(err, &breakout) {
+		t.Fatalf("expect plain link error, got %v", err)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expect not exist, got %v", err)
+	}
+	s.assertNothingOutside(t)
+}
+
+func TestApplyLayerSymlinkInRoot(t *testing.T) {
+	s := newSandbox(t)
+	//rootfs里面的相对链接照常跟随
+	layer := buildTar(t,
+		dir("usr/"),
+		dir("usr/lib/"),
+		symlink("lib", "usr/lib"),
+		dir("etc/"),
+		symlink("etc/lib", "../lib"),
+		file("lib/libc.so", "libc"),
+		file("etc/lib/libm.so", "libm"),
+	)
+	if err := ApplyLayer(layer, s.root); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"usr/lib/libc.so", "usr/lib/libm.so"} {
+		if _, err := os.Lstat(filepath.Join(s.root, name)); err != nil {
+			t.Errorf("%s not extracted,%v", name, err)
+		}
+	}
+	s.assertNothingOutside(t)
+}
+
+func TestResolveInRoot(t *testing.T) {
+	root := t.TempDir()
+	for _, d := range []string{"usr/lib", "etc"} {
+		if err := os.MkdirAll(filepath.Join(root, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"lib":      "usr/lib",
+		"etc/lib":  "../lib",
+		"loop":     "loop",
+		"abs":      "/etc",
+		"up":       "..",
+		"etc/up":   "../..",
+		"etc/self": ".",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		path     string
+		want     string
+		breakout bool
+	}{
+		{path: "usr/lib/libc.so", want: "usr/lib/libc.so"},
+		{path: "/lib/libc.so", want: "usr/lib/libc.so"},
+		{path: "etc/lib/x", want: "usr/lib/x"},
+		{path: "etc/self/self/x", want: "etc/x"},
+		{path: "not/exist", want: "not/exist"},
+		{path: "../../usr", want: "usr"},
+		{path: "lib/../etc", want: "etc"},
+		{path: "abs/passwd", want: "etc/passwd"},
+		{path: "up/x", want: "x"},
+		{path: "etc/up/x", want: "x"},
+		{path: "loop/x", breakout: true},
+	}
+	for _, tt := range tests {
+		got, err := resolveInRoot(root, tt.path)
+		if tt.breakout {
+			var breakout *BreakoutError
+			if !errors.As(err, &breakout) {
+				t.Errorf("resolveInRoot(%q) expect *BreakoutError, got %q %v", tt.path, got, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("resolveInRoot(%q),%v", tt.path, err)
+			continue
+		}
+		if want := filepath.Join(root, tt.want); got != want {
+			t.Errorf("resolveInRoot(%q) = %q, want %q", tt.path, got, want)
+		}
+	}
+}
+
+func TestResolveParent(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "usr/lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, target := range map[string]string{"lib": "usr/lib", "up": "..", "host": "/"} {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//最后一级本身不跟随，要替换的就是链接自己
+	for path, want := range map[string]string{
+		"lib":        "lib",
+		"up":         "up",
+		"host":       "host",
+		"lib/libc":   "usr/lib/libc",
+		"/lib/libc/": "usr/lib/libc",
+		//父目录穿过..和/的链接也停在root里
+		"up/x":            "x",
+		"host/etc/passwd": "etc/passwd",
+	} {
+		got, err := resolveParent(root, path)
+		if err != nil {
+			t.Errorf("resolveParent(%q),%v", path, err)
+			continue
+		}
+		if got != filepath.Join(root, want) {
+			t.Errorf("resolveParent(%q) = %q, want %q", path, got, filepath.Join(root, want))
+		}
+	}
+}
+
+func TestCheckName(t *testing.T) {
+	for name, breakout := range map[string]bool{
+		"etc/passwd":       false,
+		"./etc/passwd":     false,
+		"etc/../passwd":    false,
+		"..foo":            false,
+		"../etc/passwd":    true,
+		"etc/../../passwd": true,
+		"..":               true,
+		"/etc/passwd":      false,
+		"/../etc/passwd":   false,
+	} {
+		err := checkName(name)
+		var e *BreakoutError
+		if got := errors.As(err, &e); got != breakout {
+			t.Errorf("checkName(%q) = %v, breakout %v", name, err, breakout)
+		}
+		if breakout && !strings.Contains(err.Error(), "escapes rootfs") {
+			t.Errorf("checkName(%q) error %q", name, err)
+		}
+	}
+}
@@ -1,10 +1,17 @@
 package isolation
 
 import (
-	"log/slog"
+	"fmt"
 	"os"
 	"path"
+	"runtime"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
 )
 
 // 这里也是为了简化的硬编码嗯。。。无所谓了
@@ -12,8 +19,151 @@ const (
 	cgroupRoot = "/sys/fs/cgroup"
 )
 
+// v1每个子系统单独一棵树，容器要在每棵树里都建一个
+var subsystems = []string{"cpu", "cpuacct", "cpuset", "memory", "pids", "blkio", "freezer"}
+
+// Resources 容器的资源限制，0就是不限制
+type Resources struct {
+	//字节
+	Memory int64 `json:"memory"`
+	//内存加swap的总量，和docker的--memory-swap一样，-1是swap不限制
+	MemorySwap int64 `json:"memory_swap"`
+	//软限制，内存紧张的时候才会往这个值回收
+	MemoryReservation int64 `json:"memory_reservation"`
+	//v1的cpu.shares，v2换算成cpu.weight
+	CPUShares int64 `json:"cpu_shares"`
+	//每个周期能用多少微秒的cpu，和period一起换算成核数
+	CPUQuota  int64  `json:"cpu_quota"`
+	CPUPeriod uint64 `json:"cpu_period"`
+	//0-3,5这种格式
+	CpusetCpus string `json:"cpuset_cpus"`
+	CpusetMems string `json:"cpuset_mems"`
+	//-1是不限制
+	PidsLimit int64 `json:"pids_limit"`
+	//10到1000
+	BlkioWeight uint16 `json:"blkio_weight"`
+}
+
+// SetCPUs --cpus换算成默认周期下的quota
+func (r *Resources) SetCPUs(cpus float64) {
+	if cpus == 0 {
+		r.CPUQuota, r.CPUPeriod = 0, 0
+		return
+	}
+	r.CPUPeriod = defaultCPUPeriod
+	r.CPUQuota = int64(cpus * defaultCPUPeriod)
+}
+
+// CPUs 反过来按quota和period算核数，没限制是0
+func (r *Resources) CPUs() float64 {
+	if r.CPUQuota <= 0 || r.CPUPeriod == 0 {
+		return 0
+	}
+	return float64(r.CPUQuota) / float64(r.CPUPeriod)
+}
+
+// Validate 和docker一样在创建容器的时候就检查，不要等到启动的时候内核报EINVAL
+func (r *Resources) Validate() error {
+	//再小的话容器基本上起不来
+	if r.Memory > 0 && r.Memory < 6<<20 {
+		return fmt.Errorf("minimum memory limit allowed is 6MB")
+	}
+	if r.MemorySwap > 0 {
+		if r.Memory <= 0 {
+			return fmt.Errorf("you should always set the memory limit when using memory swap limit")
+		}
+		if r.MemorySwap < r.Memory {
+			return fmt.Errorf("minimum memory swap limit should be larger than memory limit")
+		}
+	}
+	if r.MemoryReservation > 0 && r.Memory > 0 && r.Memory < r.MemoryReservation {
+		return fmt.Errorf("minimum memory limit can not be less than memory reservation limit")
+	}
+	if r.CPUShares < 0 {
+		return fmt.Errorf("invalid cpu shares %d", r.CPUShares)
+	}
+	if cpus := r.CPUs(); cpus > 0 {
+		n := runtime.NumCPU()
+		if cpus < 0.01 || cpus > float64(n) {
+			return fmt.Errorf("range of CPUs is from 0.01 to %d.00, as there are only %d CPUs available", n, n)
+		}
+	}
+	if err := checkCpuset(r.CpusetCpus, "/sys/devices/system/cpu/online", "CPUs"); err != nil {
+		return err
+	}
+	if err := checkCpuset(r.CpusetMems, "/sys/devices/system/node/online", "memory nodes"); err != nil {
+		return err
+	}
+	if r.BlkioWeight != 0 && (r.BlkioWeight < 10 || r.BlkioWeight > 1000) {
+		return fmt.Errorf("range of blkio weight is from 10 to 1000")
+	}
+	return nil
+}
+
+// checkCpuset 格式要对，用到的cpu或者内存节点要在线
+func checkCpuset(list, onlineFile, what string) error {
+	if list == "" {
+		return nil
+	}
+	requested, err := parseCpuset(list)
+	if err != nil {
+		return fmt.Errorf("invalid value %q for cpuset %s", list, what)
+	}
+	data, err := os.ReadFile(onlineFile)
+	if err != nil {
+		//没有NUMA的机器可能没有node目录，只有0
+		data = []byte("0")
+	}
+	available, err := parseCpuset(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil
+	}
+	for n := range requested {
+		if !available[n] {
+			return fmt.Errorf("requested %s are not available - requested %s, available: %s", what, list, strings.TrimSpace(string(data)))
+		}
+	}
+	return nil
+}
+
+// parseCpuset 0-3,5 => {0,1,2,3,5}
+func parseCpuset(list string) (map[int]bool, error) {
+	set := map[int]bool{}
+	for _, part := range strings.Split(list, ",") {
+		lo, hi, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(lo)
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("invalid cpuset %q", list)
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(hi); err != nil || end < start {
+				return nil, fmt.Errorf("invalid cpuset %q", list)
+			}
+		}
+		for i := start; i <= end; i++ {
+			set[i] = true
+		}
+	}
+	return set, nil
+}
+
+// Stats 容器cgroup里的累计值，cpu要两次相减才能算百分比
+type Stats struct {
+	//纳秒
+	CPUUsage uint64
+	//和docker一样减掉了不活跃的page cache
+	MemoryUsage uint64
+	//没限制的话是宿主机的内存
+	MemoryLimit uint64
+	Pids        uint64
+	BlockRead   uint64
+	BlockWrite  uint64
+}
+
 type CgroupManager struct {
-	Name string
+	Name      string
+	Resources *Resources
 }
 
 func NewCgroupManager(id string) *CgroupManager {
@@ -22,50 +172,273 @@ func NewCgroupManager(id string) *CgroupManager {
 	}
 }
 
-func (c *CgroupManager) Set(pid int) error {
-	//需要配置cpu，内存和进程数
-	subsystems := []string{"cpu", "memory", "pids"}
+// cgroupV2 /sys/fs/cgroup本身挂的是cgroup2就是unified hierarchy
+// 只在启动的时候看一次，hybrid的机器(v1加一个/sys/fs/cgroup/unified)按v1算
+var cgroupV2 = sync.OnceValue(func() bool {
+	var st unix.Statfs_t
+	if err := unix.Statfs(cgroupRoot, &st); err != nil {
+		return false
+	}
+	return st.Type == unix.CGROUP2_SUPER_MAGIC
+})
+
+// path v2只有一个目录，v1按子系统找
+func (c *CgroupManager) path(subsystem string) string {
+	if cgroupV2() {
+		return path.Join(cgroupRoot, c.Name)
+	}
+	return path.Join(cgroupRoot, subsystem, c.Name)
+}
 
+// Path 容器的cgroup路径，和/proc/<pid>/cgroup里显示的一样
+func (c *CgroupManager) Path() string {
+	return "/" + c.Name
+}
+
+// dirs v2只有一个目录，v1每个子系统一个
+func (c *CgroupManager) dirs() []string {
+	if cgroupV2() {
+		return []string{c.path("")}
+	}
+	var dirs []string
 	for _, sub := range subsystems {
-		cgroupPath := path.Join(cgroupRoot, sub, c.Name)
-		if err := os.MkdirAll(cgroupPath, 0777); err != nil {
+		dirs = append(dirs, c.path(sub))
+	}
+	return dirs
+}
+
+// Apply 建好容器的cgroup，先写限制再把进程放进去，要在用户进程exec之前调
+// 反过来的话进程在没限制的cgroup里会有一小段时间
+func (c *CgroupManager) Apply(pid int) error {
+	if cgroupV2() {
+		if err := enableControllers(); err != nil {
+			return err
+		}
+	}
+	for _, dir := range c.dirs() {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("fail to create cgroup,%v", err)
+		}
+	}
+	if !cgroupV2() {
+		if err := initCpuset(c.path("cpuset")); err != nil {
 			return err
 		}
-		pidPath := path.Join(cgroupPath, "cgroup.procs")
-		if err := os.WriteFile(pidPath, []byte(strconv.Itoa(pid)), 0644); err != nil {
+	}
+	if c.Resources != nil {
+		if err := c.Set(c.Resources); err != nil {
 			return err
 		}
+	}
+	return c.Join(pid)
+}
 
-		if sub == "cpu" {
-			//cpu.shares用于设置CPU份额，512表示在CPU竞争时，该cgroup获得的CPU时间是512
-			if err := os.WriteFile(path.Join(cgroupPath, "cpu.shares"), []byte("512"), 0644); err != nil {
-				slog.Error("fail to set cpu shares", "error", err)
-			}
-		} else if sub == "memory" {
-			//内存上限100m
-			if err := os.WriteFile(path.Join(cgroupPath, "memory.limit_in_bytes"), []byte("100M"), 0644); err != nil {
-				slog.Error("fail to set memory limit_in_bytes", "error", err)
-			}
-		} else if sub == "pids" {
-			//最多100个进程
-			if err := os.WriteFile(path.Join(cgroupPath, "pids.max"), []byte("100"), 0644); err != nil {
-				slog.Error("fail to set pids", "error", err)
-			}
-		} else {
-			return nil
+// Set 写资源限制，容器跑着的时候也可以改
+func (c *CgroupManager) Set(r *Resources) error {
+	if cgroupV2() {
+		return c.setV2(r)
+	}
+	return c.setV1(r)
+}
+
+// Join 把进程加进已经建好的cgroup
+func (c *CgroupManager) Join(pid int) error {
+	for _, dir := range c.dirs() {
+		if err := os.WriteFile(path.Join(dir, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("fail to join cgroup %s,%v", dir, err)
 		}
 	}
 	return nil
 }
 
-func (c *CgroupManager) Remove() error {
-	subsystems := []string{"cpu", "memory", "pids"}
+// prepareExec exec的进程要一起来就在容器的cgroup里，不能fork之后再加
+// v2返回cgroup目录的fd，给clone3的CLONE_INTO_CGROUP用
+// v1没有这个，只能让当前线程先进去(写tasks只动这一个线程)，从这个线程fork出来的子进程天生就在里面
+// 所以调用的线程必须是锁住的、用完就扔掉的，v1返回-1
+func (c *CgroupManager) prepareExec() (int, error) {
+	if cgroupV2() {
+		fd, err := unix.Open(c.path(""), unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return -1, fmt.Errorf("fail to open cgroup,%v", err)
+		}
+		return fd, nil
+	}
+	tid := strconv.Itoa(unix.Gettid())
+	for _, dir := range c.dirs() {
+		if err := os.WriteFile(path.Join(dir, "tasks"), []byte(tid), 0644); err != nil {
+			return -1, fmt.Errorf("fail to join cgroup %s,%v", dir, err)
+		}
+	}
+	return -1, nil
+}
 
-	for _, sub := range subsystems {
-		cgroupPath := path.Join(cgroupRoot, sub, c.Name)
-		if err := os.RemoveAll(cgroupPath); err != nil {
+// Stats 容器退出之后cgroup删了，这时候会报错
+func (c *CgroupManager) Stats() (*Stats, error) {
+	var stats *Stats
+	var err error
+	if cgroupV2() {
+		stats, err = c.statsV2()
+	} else {
+		stats, err = c.statsV1()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if host := hostMemory(); stats.MemoryLimit == 0 || stats.MemoryLimit > host {
+		stats.MemoryLimit = host
+	}
+	return stats, nil
+}
+
+func hostMemory() uint64 {
+	var info unix.Sysinfo_t
+	if err := unix.Sysinfo(&info); err != nil {
+		return 0
+	}
+	return uint64(info.Totalram) * uint64(info.Unit)
+}
+
+// readUint 一个数字的控制文件，max当成0
+func readUint(dir, file string) (uint64, error) {
+	data, err := os.ReadFile(path.Join(dir, file))
+	if err != nil {
+		return 0, err
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "max" {
+		return 0, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// readKeyValues memory.stat、cpu.stat这种一行一个key value的
+func readKeyValues(dir, file string) (map[string]uint64, error) {
+	data, err := os.ReadFile(path.Join(dir, file))
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]uint64{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if v, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			values[fields[0]] = v
+		}
+	}
+	return values, nil
+}
+
+// Freeze v2写cgroup.freeze，v1写freezer.state，都要等内核真正冻结完
+func (c *CgroupManager) Freeze() error {
+	return c.setFrozen(true)
+}
+
+func (c *CgroupManager) Thaw() error {
+	return c.setFrozen(false)
+}
+
+func (c *CgroupManager) setFrozen(frozen bool) error {
+	var file, value, stateFile, want string
+	if cgroupV2() {
+		file, value = path.Join(c.path(""), "cgroup.freeze"), "0"
+		stateFile, want = path.Join(c.path(""), "cgroup.events"), "frozen 0"
+		if frozen {
+			value, want = "1", "frozen 1"
+		}
+	} else {
+		file, value = path.Join(c.path("freezer"), "freezer.state"), "THAWED"
+		stateFile, want = file, "THAWED"
+		if frozen {
+			value, want = "FROZEN", "FROZEN"
+		}
+	}
+	if err := os.WriteFile(file, []byte(value), 0644); err != nil {
+		return fmt.Errorf("fail to write %s,%v", file, err)
+	}
+	for i := 0; i < 1000; i++ {
+		data, err := os.ReadFile(stateFile)
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if strings.TrimSpace(line) == want {
+				return nil
+			}
+		}
+		//v1冻结的时候可能卡在FREEZING，再写一次
+		if frozen && !cgroupV2() {
+			os.WriteFile(file, []byte(value), 0644)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return fmt.Errorf("timeout waiting for cgroup %s to be %s", c.Name, want)
+}
+
+// Remove 容器刚退出的时候内核可能还没把进程从cgroup里清干净，rmdir会EBUSY，等它空了再删
+func (c *CgroupManager) Remove() error {
+	for _, dir := range c.dirs() {
+		if err := removeCgroup(dir); err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+func removeCgroup(dir string) error {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := syscall.Rmdir(dir)
+		if err == nil || os.IsNotExist(err) {
+			return nil
+		}
+		if err != syscall.EBUSY || time.Now().After(deadline) {
+			return fmt.Errorf("fail to remove cgroup %s,%v", dir, err)
+		}
+		waitEmpty(dir, deadline)
+	}
+}
+
+// waitEmpty cgroup.procs读出来是空的就说明进程都走了，v2的cgroup.events还要等populated变成0
+func waitEmpty(dir string, deadline time.Time) {
+	for time.Now().Before(deadline) {
+		procs, err := os.ReadFile(path.Join(dir, "cgroup.procs"))
+		if err != nil {
+			return
+		}
+		populated := false
+		if events, err := os.ReadFile(path.Join(dir, "cgroup.events")); err == nil {
+			populated = strings.Contains(string(events), "populated 1")
+		}
+		if len(strings.TrimSpace(string(procs))) == 0 && !populated {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// writeFile 写cgroup的控制文件，报错的时候带上文件名和值
+func writeFile(dir, file, value string) error {
+	if err := os.WriteFile(path.Join(dir, file), []byte(value), 0644); err != nil {
+		return fmt.Errorf("fail to write %s=%s,%w", file, value, err)
+	}
+	return nil
+}
+
+// OOMKilled 看内存cgroup里有没有发生过oom kill，v1和v2的文件不一样
+func (c *CgroupManager) OOMKilled() bool {
+	for _, file := range []string{"memory.events", "memory.oom_control"} {
+		data, err := os.ReadFile(path.Join(c.path("memory"), file))
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 2 && fields[0] == "oom_kill" && fields[1] != "0" {
+				return true
+			}
+		}
+	}
+	return false
+}
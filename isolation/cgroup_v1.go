@@ -0,0 +1,190 @@
+package isolation
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// setV1 每个限制写到各自子系统的目录里
+func (c *CgroupManager) setV1(r *Resources) error {
+	cpu := c.path("cpu")
+	if r.CPUShares > 0 {
+		if err := writeFile(cpu, "cpu.shares", strconv.FormatInt(r.CPUShares, 10)); err != nil {
+			return err
+		}
+	}
+	//period要先写，quota的合法范围和period有关
+	if r.CPUPeriod > 0 {
+		if err := writeFile(cpu, "cpu.cfs_period_us", strconv.FormatUint(r.CPUPeriod, 10)); err != nil {
+			return err
+		}
+	}
+	if r.CPUQuota != 0 {
+		if err := writeFile(cpu, "cpu.cfs_quota_us", strconv.FormatInt(r.CPUQuota, 10)); err != nil {
+			return err
+		}
+	}
+	if err := setCpusetV1(c.path("cpuset"), r.CpusetCpus, r.CpusetMems); err != nil {
+		return err
+	}
+	if err := setMemoryV1(c.path("memory"), r.Memory, r.MemorySwap); err != nil {
+		return err
+	}
+	if r.MemoryReservation != 0 {
+		if err := writeFile(c.path("memory"), "memory.soft_limit_in_bytes", strconv.FormatInt(r.MemoryReservation, 10)); err != nil {
+			return err
+		}
+	}
+	if r.PidsLimit != 0 {
+		if err := writeFile(c.path("pids"), "pids.max", pidsMax(r.PidsLimit)); err != nil {
+			return err
+		}
+	}
+	if r.BlkioWeight != 0 {
+		//cfq的是blkio.weight，新内核只有bfq的
+		if err := writeWeight(c.path("blkio"), []string{"blkio.weight", "blkio.bfq.weight"}, r.BlkioWeight); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// initCpuset v1新建的cpuset是空的，不填上cpus和mems的话进程加不进去，和父cgroup一样就行
+func initCpuset(dir string) error {
+	for _, file := range []string{"cpuset.cpus", "cpuset.mems"} {
+		data, err := os.ReadFile(path.Join(dir, file))
+		if err != nil {
+			return fmt.Errorf("fail to read %s,%v", file, err)
+		}
+		if strings.TrimSpace(string(data)) != "" {
+			continue
+		}
+		parent, err := os.ReadFile(path.Join(path.Dir(dir), file))
+		if err != nil {
+			return fmt.Errorf("fail to read %s,%v", file, err)
+		}
+		if err := writeFile(dir, file, strings.TrimSpace(string(parent))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setCpusetV1(dir, cpus, mems string) error {
+	if cpus != "" {
+		if err := writeFile(dir, "cpuset.cpus", cpus); err != nil {
+			return err
+		}
+	}
+	if mems != "" {
+		if err := writeFile(dir, "cpuset.mems", mems); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeWeight 用第一个存在的文件，内核的io调度器不支持权重的话和docker一样忽略掉
+func writeWeight(dir string, files []string, weight uint16) error {
+	for _, file := range files {
+		if _, err := os.Stat(path.Join(dir, file)); err == nil {
+			return writeFile(dir, file, strconv.FormatUint(uint64(weight), 10))
+		}
+	}
+	slog.Warn("kernel does not support block I/O weight, discarded", "dir", dir)
+	return nil
+}
+
+// setMemoryV1 memsw是内存加swap的总量，内核要求任何时候都不能比limit_in_bytes小
+// 所以调大的时候要先改memsw，调小的时候先改limit
+func setMemoryV1(dir string, memory, swap int64) error {
+	if swap != 0 {
+		if _, err := os.Stat(path.Join(dir, "memory.memsw.limit_in_bytes")); err != nil {
+			return fmt.Errorf("kernel does not support swap limit (swapaccount=1)")
+		}
+	}
+	setMemory := func() error {
+		if memory == 0 {
+			return nil
+		}
+		return writeFile(dir, "memory.limit_in_bytes", strconv.FormatInt(memory, 10))
+	}
+	setSwap := func() error {
+		if swap == 0 {
+			return nil
+		}
+		return writeFile(dir, "memory.memsw.limit_in_bytes", strconv.FormatInt(swap, 10))
+	}
+	err := setMemory()
+	if err == nil {
+		return setSwap()
+	}
+	if !errors.Is(err, syscall.EINVAL) || swap == 0 {
+		return err
+	}
+	if err := setSwap(); err != nil {
+		return err
+	}
+	return setMemory()
+}
+
+func pidsMax(limit int64) string {
+	if limit < 0 {
+		return "max"
+	}
+	return strconv.FormatInt(limit, 10)
+}
+
+// statsV1 cpu和内存是必须的，pids和blkio子系统没有就算0
+func (c *CgroupManager) statsV1() (*Stats, error) {
+	stats := &Stats{}
+	var err error
+	if stats.CPUUsage, err = readUint(c.path("cpuacct"), "cpuacct.usage"); err != nil {
+		return nil, fmt.Errorf("fail to read cpu usage,%v", err)
+	}
+	memory := c.path("memory")
+	usage, err := readUint(memory, "memory.usage_in_bytes")
+	if err != nil {
+		return nil, fmt.Errorf("fail to read memory usage,%v", err)
+	}
+	if memStat, err := readKeyValues(memory, "memory.stat"); err == nil && memStat["total_inactive_file"] < usage {
+		usage -= memStat["total_inactive_file"]
+	}
+	stats.MemoryUsage = usage
+	stats.MemoryLimit, _ = readUint(memory, "memory.limit_in_bytes")
+	stats.Pids, _ = readUint(c.path("pids"), "pids.current")
+	stats.BlockRead, stats.BlockWrite = blkioBytesV1(c.path("blkio"))
+	return stats, nil
+}
+
+// blkioBytesV1 每行是 8:0 Read 4096 这样，cfq的文件新内核没有了，用throttle的
+func blkioBytesV1(dir string) (uint64, uint64) {
+	var read, write uint64
+	for _, file := range []string{"blkio.throttle.io_service_bytes_recursive", "blkio.io_service_bytes_recursive"} {
+		data, err := os.ReadFile(path.Join(dir, file))
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) != 3 {
+				continue
+			}
+			v, _ := strconv.ParseUint(fields[2], 10, 64)
+			switch fields[1] {
+			case "Read":
+				read += v
+			case "Write":
+				write += v
+			}
+		}
+		break
+	}
+	return read, write
+}
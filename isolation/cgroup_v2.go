@@ -0,0 +1,204 @@
+package isolation
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// 容器用到的控制器，freeze是v2自带的不用开
+var v2Controllers = []string{"cpu", "cpuset", "memory", "pids", "io"}
+
+// cfs默认的周期是100ms
+const defaultCPUPeriod = 100000
+
+// enableControllers v2的控制器要在父cgroup的subtree_control里打开，子cgroup里才有对应的文件
+// 容器的cgroup直接建在根下面，所以只用开根的
+func enableControllers() error {
+	data, err := os.ReadFile(path.Join(cgroupRoot, "cgroup.controllers"))
+	if err != nil {
+		return fmt.Errorf("fail to read cgroup controllers,%v", err)
+	}
+	available := strings.Fields(string(data))
+	for _, name := range v2Controllers {
+		if !contains(available, name) {
+			continue
+		}
+		if err := writeFile(cgroupRoot, "cgroup.subtree_control", "+"+name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// setV2 所有限制都在同一个目录里，文件名和格式和v1不一样
+func (c *CgroupManager) setV2(r *Resources) error {
+	dir := c.path("")
+	if r.CPUShares > 0 {
+		if err := writeFile(dir, "cpu.weight", strconv.FormatUint(sharesToWeight(r.CPUShares), 10)); err != nil {
+			return err
+		}
+	}
+	if r.CPUQuota != 0 || r.CPUPeriod != 0 {
+		period := r.CPUPeriod
+		if period == 0 {
+			period = defaultCPUPeriod
+		}
+		quota := "max"
+		if r.CPUQuota > 0 {
+			quota = strconv.FormatInt(r.CPUQuota, 10)
+		}
+		if err := writeFile(dir, "cpu.max", fmt.Sprintf("%s %d", quota, period)); err != nil {
+			return err
+		}
+	}
+	if r.CpusetCpus != "" {
+		if err := writeFile(dir, "cpuset.cpus", r.CpusetCpus); err != nil {
+			return err
+		}
+	}
+	if r.CpusetMems != "" {
+		if err := writeFile(dir, "cpuset.mems", r.CpusetMems); err != nil {
+			return err
+		}
+	}
+	if r.MemoryReservation != 0 {
+		if err := writeFile(dir, "memory.low", limitValue(r.MemoryReservation)); err != nil {
+			return err
+		}
+	}
+	if r.Memory != 0 {
+		if err := writeFile(dir, "memory.max", limitValue(r.Memory)); err != nil {
+			return err
+		}
+	}
+	swap, err := swapMaxV2(r.Memory, r.MemorySwap)
+	if err != nil {
+		return err
+	}
+	if swap != "" {
+		//没开swap记账的内核没有这个文件，和docker一样不管swap
+		if _, err := os.Stat(path.Join(dir, "memory.swap.max")); err != nil {
+			slog.Warn("kernel does not support swap limit, discarded", "dir", dir)
+		} else if err := writeFile(dir, "memory.swap.max", swap); err != nil {
+			return err
+		}
+	}
+	if r.PidsLimit != 0 {
+		if err := writeFile(dir, "pids.max", pidsMax(r.PidsLimit)); err != nil {
+			return err
+		}
+	}
+	if r.BlkioWeight != 0 {
+		//bfq的权重和v1一样是1到1000，直接写；没有bfq就换算成io.weight
+		if _, err := os.Stat(path.Join(dir, "io.bfq.weight")); err == nil {
+			return writeFile(dir, "io.bfq.weight", strconv.FormatUint(uint64(r.BlkioWeight), 10))
+		}
+		return writeWeight(dir, []string{"io.weight"}, blkioToIOWeight(r.BlkioWeight))
+	}
+	return nil
+}
+
+// blkioToIOWeight 和runc一样把[10,1000]映射到[1,10000]
+func blkioToIOWeight(weight uint16) uint16 {
+	return uint16(1 + (uint64(weight)-10)*9999/990)
+}
+
+// sharesToWeight 用runc新版的二次曲线把[2,262144]映射到[1,10000]
+// 两头还是1和10000，默认的1024正好是100，和docker在v2上一样；旧的线性公式1024只有39
+func sharesToWeight(shares int64) uint64 {
+	if shares <= 2 {
+		return 1
+	}
+	if shares >= 262144 {
+		return 10000
+	}
+	l := math.Log2(float64(shares))
+	exponent := (l*l+125*l)/612.0 - 7.0/34.0
+	return uint64(math.Ceil(math.Pow(10, exponent)))
+}
+
+// swapMaxV2 v2的swap.max只算swap，docker的memory-swap是内存加swap，要减掉
+// 只设了内存的话和docker一样，swap给一样多，加起来是内存的两倍；返回空的就是不用写
+func swapMaxV2(memory, memorySwap int64) (string, error) {
+	switch {
+	case memorySwap < 0:
+		return "max", nil
+	case memorySwap > 0:
+		if memory <= 0 {
+			return "", fmt.Errorf("memory swap limit requires a memory limit")
+		}
+		return strconv.FormatInt(memorySwap-memory, 10), nil
+	case memory > 0:
+		return strconv.FormatInt(memory, 10), nil
+	}
+	return "", nil
+}
+
+func limitValue(v int64) string {
+	if v < 0 {
+		return "max"
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+// statsV2 cpu.stat里的是微秒
+func (c *CgroupManager) statsV2() (*Stats, error) {
+	dir := c.path("")
+	stats := &Stats{}
+	cpuStat, err := readKeyValues(dir, "cpu.stat")
+	if err != nil {
+		return nil, fmt.Errorf("fail to read cpu usage,%v", err)
+	}
+	stats.CPUUsage = cpuStat["usage_usec"] * 1000
+	usage, err := readUint(dir, "memory.current")
+	if err != nil {
+		return nil, fmt.Errorf("fail to read memory usage,%v", err)
+	}
+	if memStat, err := readKeyValues(dir, "memory.stat"); err == nil && memStat["inactive_file"] < usage {
+		usage -= memStat["inactive_file"]
+	}
+	stats.MemoryUsage = usage
+	stats.MemoryLimit, _ = readUint(dir, "memory.max")
+	stats.Pids, _ = readUint(dir, "pids.current")
+	stats.BlockRead, stats.BlockWrite = ioBytesV2(dir)
+	return stats, nil
+}
+
+// ioBytesV2 每行是 8:0 rbytes=4096 wbytes=0 rios=1 ... 这样，每个设备一行
+func ioBytesV2(dir string) (uint64, uint64) {
+	var read, write uint64
+	data, err := os.ReadFile(path.Join(dir, "io.stat"))
+	if err != nil {
+		return 0, 0
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		for _, field := range strings.Fields(line) {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+			v, _ := strconv.ParseUint(value, 10, 64)
+			switch key {
+			case "rbytes":
+				read += v
+			case "wbytes":
+				write += v
+			}
+		}
+	}
+	return read, write
+}
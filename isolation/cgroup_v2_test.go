@@ -0,0 +1,57 @@
+package isolation
+
+import "testing"
+
+func TestSharesToWeight(t *testing.T) {
+	for shares, want := range map[int64]uint64{
+		0:      1,
+		2:      1,
+		512:    59,
+		1024:   100,
+		2048:   174,
+		262144: 10000,
+		300000: 10000,
+	} {
+		if got := sharesToWeight(shares); got != want {
+			t.Errorf("sharesToWeight(%d) = %d, want %d", shares, got, want)
+		}
+	}
+	//越大权重越大
+	last := uint64(0)
+	for shares := int64(2); shares <= 262144; shares *= 2 {
+		w := sharesToWeight(shares)
+		if w < last {
+			t.Errorf("sharesToWeight(%d) = %d, smaller than %d", shares, w, last)
+		}
+		last = w
+	}
+}
+
+func TestSwapMaxV2(t *testing.T) {
+	const mb = 1 << 20
+	tests := []struct {
+		memory, memorySwap int64
+		want               string
+	}{
+		{memory: 0, memorySwap: 0, want: ""},
+		{memory: -1, memorySwap: 0, want: ""},
+		//只设内存，swap和内存一样多
+		{memory: 512 * mb, memorySwap: 0, want: "536870912"},
+		{memory: 512 * mb, memorySwap: 1024 * mb, want: "536870912"},
+		{memory: 512 * mb, memorySwap: 512 * mb, want: "0"},
+		{memory: 512 * mb, memorySwap: -1, want: "max"},
+	}
+	for _, tt := range tests {
+		got, err := swapMaxV2(tt.memory, tt.memorySwap)
+		if err != nil {
+			t.Errorf("swapMaxV2(%d, %d),%v", tt.memory, tt.memorySwap, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("swapMaxV2(%d, %d) = %q, want %q", tt.memory, tt.memorySwap, got, tt.want)
+		}
+	}
+	if _, err := swapMaxV2(0, 1024*mb); err == nil {
+		t.Error("swap limit without memory limit should fail")
+	}
+}
@@ -0,0 +1,190 @@
+package isolation
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// ExecConfig 在已经跑起来的容器里再起一个进程
+type ExecConfig struct {
+	ID   string
+	Pid  int
+	Args []string
+	Env  []string
+	Dir  string
+	User string
+	Tty  bool
+}
+
+// 按这个顺序进，mnt放最后，进了mnt之后/proc就是容器里的了
+var execNamespaces = []struct {
+	name string
+	flag int
+}{
+	{"ipc", unix.CLONE_NEWIPC},
+	{"uts", unix.CLONE_NEWUTS},
+	{"net", unix.CLONE_NEWNET},
+	{"pid", unix.CLONE_NEWPID},
+	{"mnt", unix.CLONE_NEWNS},
+}
+
+// ExecInContainer setns进容器init进程的各个namespace，chroot到它的根目录，再在容器的cgroup里起用户的进程
+// Tty的时候第二个返回值是pty的master
+//
+// setns和v1的cgroup都只对当前线程生效，所以整个过程放在一个锁住线程的goroutine里做，fork也从这个线程出去
+// goroutine结束的时候不解锁，这个进过容器的线程会被runtime直接扔掉，不会再跑别的goroutine
+func ExecInContainer(config *ExecConfig, stdio *Stdio) (*exec.Cmd, *os.File, error) {
+	slog.Info("exec in container", "id", config.ID, "command", config.Args)
+
+	//没给的stdio exec会去开/dev/null，这时候已经在容器里了，镜像里不一定有
+	devNull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer devNull.Close()
+	if stdio == nil {
+		stdio = &Stdio{}
+	}
+
+	type result struct {
+		cmd     *exec.Cmd
+		console *os.File
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		runtime.LockOSThread()
+		cmd, console, err := execInNamespaces(config, stdio, devNull)
+		done <- result{cmd, console, err}
+	}()
+	r := <-done
+	return r.cmd, r.console, r.err
+}
+
+func execInNamespaces(config *ExecConfig, stdio *Stdio, devNull *os.File) (*exec.Cmd, *os.File, error) {
+	//namespace的fd和容器的根目录都要在进mnt之前打开
+	var fds []int
+	defer func() {
+		for _, fd := range fds {
+			unix.Close(fd)
+		}
+	}()
+	for _, ns := range execNamespaces {
+		fd, err := unix.Open(fmt.Sprintf("/proc/%d/ns/%s", config.Pid, ns.name), unix.O_RDONLY|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return nil, nil, fmt.Errorf("fail to open %s namespace,%v", ns.name, err)
+		}
+		fds = append(fds, fd)
+	}
+	root, err := unix.Open(fmt.Sprintf("/proc/%d/root", config.Pid), unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("fail to open container root,%v", err)
+	}
+	fds = append(fds, root)
+	//cgroup也要在进mnt之前弄，进去之后看到的/sys/fs/cgroup就是容器里的了
+	cgroupFd, err := NewCgroupManager(config.ID).prepareExec()
+	if err != nil {
+		return nil, nil, err
+	}
+	if cgroupFd >= 0 {
+		fds = append(fds, cgroupFd)
+	}
+
+	//Go的线程共享fs信息，不先拆开的话setns进mnt会EINVAL
+	if err := unix.Unshare(unix.CLONE_FS); err != nil {
+		return nil, nil, fmt.Errorf("fail to unshare fs,%v", err)
+	}
+	for i, ns := range execNamespaces {
+		if err := unix.Setns(fds[i], ns.flag); err != nil {
+			return nil, nil, fmt.Errorf("fail to join %s namespace,%v", ns.name, err)
+		}
+	}
+	//init是chroot进rootfs的，这里也要到同一个根目录
+	if err := unix.Fchdir(root); err != nil {
+		return nil, nil, fmt.Errorf("fail to enter container root,%v", err)
+	}
+	if err := unix.Chroot("."); err != nil {
+		return nil, nil, fmt.Errorf("fail to chroot,%v", err)
+	}
+
+	//下面读的/etc/passwd、PATH里的文件都是容器里的
+	user, err := lookupUser(config.User)
+	if err != nil {
+		return nil, nil, err
+	}
+	env := config.Env
+	if !hasEnv(env, "HOME") {
+		env = append(env, "HOME="+user.Home)
+	}
+	if len(config.Args) == 0 {
+		return nil, nil, fmt.Errorf("empty command")
+	}
+	commandPath, err := lookPath(config.Args[0], env)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cmd := &exec.Cmd{
+		Path: commandPath,
+		Args: config.Args,
+		Env:  env,
+		Dir:  config.Dir,
+		SysProcAttr: &syscall.SysProcAttr{
+			Credential: &syscall.Credential{
+				Uid:    uint32(user.Uid),
+				Gid:    uint32(user.Gid),
+				Groups: []uint32{uint32(user.Gid)},
+			},
+		},
+		Stdin:  stdio.Stdin,
+		Stdout: stdio.Stdout,
+		Stderr: stdio.Stderr,
+	}
+	if cgroupFd >= 0 {
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = cgroupFd
+	}
+	if cmd.Dir == "" {
+		cmd.Dir = "/"
+	}
+	if cmd.Stdin == nil {
+		cmd.Stdin = devNull
+	}
+	if cmd.Stdout == nil {
+		cmd.Stdout = devNull
+	}
+	if cmd.Stderr == nil {
+		cmd.Stderr = devNull
+	}
+
+	if !config.Tty {
+		if err := cmd.Start(); err != nil {
+			return nil, nil, fmt.Errorf("fail to exec in container,%v", err)
+		}
+		return cmd, nil, nil
+	}
+
+	master, slave, err := openPty()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer slave.Close()
+	if err := slave.Chown(user.Uid, user.Gid); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("fail to chown pty,%v", err)
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	if err := cmd.Start(); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("fail to exec in container,%v", err)
+	}
+	return cmd, master, nil
+}
@@ -40,7 +40,8 @@ func setMount(rootfs string) error {
 
 	//tmpfs系统提供临时文件存储
 	tmpfsPath := path.Join(rootfs, "tmpfs")
-	if err := os.Mkdir(tmpfsPath, 0755); err != nil {
+	//容器再次start的时候目录已经在了
+	if err := os.MkdirAll(tmpfsPath, 0755); err != nil {
 		slog.Error("fail to create tmp dir", "error", err)
 	}
 	if err := syscall.Mount("tmpfs", tmpfsPath, "tmpfs", 0, ""); err != nil {
@@ -52,9 +53,17 @@ func setMount(rootfs string) error {
 	if err := os.MkdirAll(devptsPath, 0755); err != nil {
 		return fmt.Errorf("fail to mount devpts,%v", err)
 	}
-	if err := syscall.Mount("devpts", devptsPath, "devpts", 0, ""); err != nil {
+	//和docker一样单独一个实例，容器里开的pty宿主机上看不到
+	if err := syscall.Mount("devpts", devptsPath, "devpts", syscall.MS_NOSUID|syscall.MS_NOEXEC, "newinstance,ptmxmode=0666,mode=0620"); err != nil {
 		return fmt.Errorf("fail to mount devpts,%v", err)
 	}
+	//镜像里的/dev一般是空的，ptmx指到自己实例里的那个
+	ptmx := path.Join(rootfs, "dev", "ptmx")
+	if _, err := os.Lstat(ptmx); os.IsNotExist(err) {
+		if err := os.Symlink("pts/ptmx", ptmx); err != nil {
+			slog.Error("fail to create ptmx", "error", err)
+		}
+	}
 	slog.Info("all success")
 	return nil
 }
@@ -2,7 +2,10 @@ package isolation
 
 import (
 	"docker/network"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
 	"os"
@@ -12,24 +15,34 @@ import (
 	"syscall"
 
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 )
 
-var DefaultNetwork = &network.Network{
-	Name:    "bridge",
-	GateWay: "10.0.0.1/24",
-	Subnet:  "10.0.0.0/24",
-}
+// 父进程把这个通过管道传给容器里的init进程
+const initPipeEnv = "_EASYDOCKER_INITPIPE"
 
 type ContainerConfig struct {
-	ID      string
-	Name    string
-	Image   string
-	Command string
-	Pid     int64
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Image   string   `json:"image"`
+	Command string   `json:"command"`
+	Pid     int64    `json:"pid"`
+	Rootfs  string   `json:"rootfs"`
+	Args    []string `json:"args"`
+	Env     []string `json:"env"`
+	Dir     string   `json:"dir"`
+	User    string   `json:"user"`
+	Tty     bool     `json:"tty"`
+	//init进程用不到，父进程建cgroup的时候用
+	Resources *Resources `json:"resources,omitempty"`
 }
 
 func setHostName(ID string) error {
+	//和docker一样用短ID
 	hostname := ID
+	if len(hostname) > 12 {
+		hostname = hostname[:12]
+	}
 
 	//设置hostname
 	if err := syscall.Sethostname([]byte(hostname)); err != nil {
@@ -40,12 +53,35 @@ func setHostName(ID string) error {
 	return nil
 }
 
-func executeCommand(cmd []string) error {
+// exec.LookPath用的是当前进程的PATH，这里要按容器的env来找
+func lookPath(file string, env []string) (string, error) {
+	if strings.Contains(file, "/") {
+		return file, nil
+	}
+	pathEnv := ""
+	for _, kv := range env {
+		if v, ok := strings.CutPrefix(kv, "PATH="); ok {
+			pathEnv = v
+		}
+	}
+	for _, dir := range strings.Split(pathEnv, ":") {
+		if dir == "" {
+			dir = "."
+		}
+		p := path.Join(dir, file)
+		if info, err := os.Stat(p); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("executable file %s not found in $PATH", file)
+}
+
+func executeCommand(cmd []string, env []string) error {
 	if len(cmd) == 0 {
 		return fmt.Errorf("empty command")
 	}
 
-	commandPath, err := exec.LookPath(cmd[0])
+	commandPath, err := lookPath(cmd[0], env)
 	if err != nil {
 		slog.Error("fail to find command", "command", cmd[0], "error", err)
 		return err
@@ -54,14 +90,6 @@ func executeCommand(cmd []string) error {
 	argv := make([]string, 0, len(cmd))
 	argv = append(argv, cmd...)
 
-	//设置环境变量
-	//这个你要用自己手动设置吧唉
-	env := []string{
-		"PATH=",
-		"TERM=",
-		"HOME=",
-	}
-
 	slog.Info("executing command", "path", commandPath, "args", argv)
 
 	if err := syscall.Exec(commandPath, argv, env); err != nil {
@@ -85,7 +113,9 @@ func SetNameSpace(id string, pid int) error {
 func cleanVethInterface(name string) error {
 	link, err := netlink.LinkByName(name)
 	if err != nil {
-		if strings.Contains(err.Error(), "no such network interface") {
+		//容器的netns没了veth pair会跟着一起删掉，一般走这里
+		var notFound netlink.LinkNotFoundError
+		if errors.As(err, &notFound) {
 			slog.Info("already removed", "interface", name)
 			return nil
 		}
@@ -112,7 +142,7 @@ func cleanRoutes(ID string) error {
 	if err != nil {
 		return fmt.Errorf("fail to list routes,%v", err)
 	}
-	hostInterface := path.Join("veth_", ID)
+	hostInterface := network.HostInterface(ID)
 	count := 0
 
 	for _, route := range routes {
@@ -121,7 +151,7 @@ func cleanRoutes(ID string) error {
 			if err != nil && strings.Contains(err.Error(), "no such interface") {
 				continue
 			} else if err != nil {
-				slog.Error("fail to get link by index,%v", err)
+				slog.Error("fail to get link by index", "error", err)
 				continue
 			}
 			if link.Attrs().Name == hostInterface {
@@ -142,7 +172,6 @@ func cleanRoutes(ID string) error {
 func cleanNATRule(id string) error {
 	rules := []string{
 		//这里面写你需要的规则
-		fmt.Sprintf(""),
 	}
 	for _, rule := range rules {
 		cmd := exec.Command("iptables", "-t", "nat", "-D", rule)
@@ -158,10 +187,8 @@ func cleanNATRule(id string) error {
 }
 
 func cleanFilter(ID string) error {
-	hostInterface := path.Join("veth_", ID)
 	rules := []string{
-		fmt.Sprintf("%s%s", hostInterface, ID),
-		//还是写你要清的rule
+		//还是写你要清的rule，现在没有给容器加过filter规则
 	}
 	for _, rule := range rules {
 		cmd := exec.Command("iptables", "-D", rule)
@@ -175,7 +202,7 @@ func cleanFilter(ID string) error {
 }
 
 func cleanResource(id string) error {
-	hostInterface := path.Join("veth_", id)
+	hostInterface := network.HostInterface(id)
 
 	if err := cleanVethInterface(hostInterface); err != nil {
 		return fmt.Errorf("fail to clean veth interface,%v", err)
@@ -194,6 +221,11 @@ func cleanResource(id string) error {
 }
 
 func CleanNameSpace(id string) error {
+	//地址不管veth有没有清干净都要还回去，不然一直占着
+	if err := network.ReleaseIP(id); err != nil {
+		slog.Error("fail to release ip", "id", id, "error", err)
+		return fmt.Errorf("fail to release ip,%v", err)
+	}
 	if err := cleanResource(id); err != nil {
 		slog.Error("fail to clean resource", "id", id)
 		return fmt.Errorf("fail to clean resource,%v", err)
@@ -201,8 +233,17 @@ func CleanNameSpace(id string) error {
 	return nil
 }
 
-func StartContainer(ID, rootfs string, cmd []string, interactive bool) (int, error) {
-	slog.Info("start container", "containID", ID, "command", cmd)
+// Stdio 容器init进程的标准输入输出，nil的就是/dev/null
+type Stdio struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// StartContainer 启动容器的init进程，返回的cmd要由调用方Wait回收
+// config.Tty的时候第二个返回值是容器里pty的master，init的输入输出都接在pty上
+func StartContainer(config *ContainerConfig, stdio *Stdio) (*exec.Cmd, *os.File, error) {
+	slog.Info("start container", "containID", config.ID, "command", config.Args)
 
 	c := exec.Command("/proc/self/exe", "init")
 
@@ -214,53 +255,170 @@ func StartContainer(ID, rootfs string, cmd []string, interactive bool) (int, err
 			syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC,
 	} //CLONE_NEWUTS: 隔离主机名和域名，CLONE_NEWPID: 隔离进程ID空间，CLONE_NEWNS: 隔离挂载点，CLONE_NEWNET: 隔离网络栈，CLONE_NEWIPC: 隔离IPC
 
-	if interactive {
-		c.Stdin = os.Stdin
-		c.Stdout = os.Stdout
-		c.Stderr = os.Stderr
+	if stdio != nil {
+		c.Stdin = stdio.Stdin
+		c.Stdout = stdio.Stdout
+		c.Stderr = stdio.Stderr
 	}
 
-	c.Dir = rootfs
+	c.Dir = config.Rootfs
 
-	if err := c.Start(); err != nil {
-		return 0, err
+	//ExtraFiles里第一个就是子进程的fd 3
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("fail to create init pipe,%v", err)
+	}
+	defer writer.Close()
+	c.ExtraFiles = []*os.File{reader}
+	c.Env = append(os.Environ(), initPipeEnv+"=3")
+
+	//pty要在容器自己的devpts里开，init开好之后从fd 4把master发回来
+	var console *os.File
+	if config.Tty {
+		fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+		if err != nil {
+			reader.Close()
+			return nil, nil, fmt.Errorf("fail to create console socket,%v", err)
+		}
+		console = os.NewFile(uintptr(fds[0]), "console-socket")
+		defer console.Close()
+		c.ExtraFiles = append(c.ExtraFiles, os.NewFile(uintptr(fds[1]), "console-socket"))
+		c.Env = append(c.Env, consoleEnv+"=4")
 	}
 
-	return c.Process.Pid, nil
+	err = c.Start()
+	//子进程那一端都要关掉，不然init出错退出了这边也读不到EOF
+	for _, f := range c.ExtraFiles {
+		f.Close()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	//init进程在读到配置之前不会往下走，cgroup和网络要趁这时候配好
+	cgroup := NewCgroupManager(config.ID)
+	cgroup.Resources = config.Resources
+	if err := cgroup.Apply(c.Process.Pid); err != nil {
+		c.Process.Kill()
+		c.Wait()
+		return nil, nil, err
+	}
+	if err := SetNameSpace(config.ID, c.Process.Pid); err != nil {
+		slog.Warn("container started without network", "id", config.ID, "error", err)
+	}
+
+	if err := json.NewEncoder(writer).Encode(config); err != nil {
+		c.Process.Kill()
+		c.Wait()
+		return nil, nil, fmt.Errorf("fail to send init config,%v", err)
+	}
+	if console == nil {
+		return c, nil, nil
+	}
+	master, err := recvFd(console)
+	if err != nil {
+		c.Process.Kill()
+		c.Wait()
+		return nil, nil, fmt.Errorf("fail to receive console,%v", err)
+	}
+	return c, master, nil
 }
 
-func InitProcess(ID string, rootfs string, cmd []string) error {
-	slog.Info("container init", "containerID", ID)
+func InitProcess(config *ContainerConfig) error {
+	slog.Info("container init", "containerID", config.ID)
 
-	if err := setHostName(ID); err != nil {
+	//挂载点改成私有的，不然容器里的挂载会传播回宿主机
+	if err := syscall.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("fail to make root private,%v", err)
+	}
+	if err := setHostName(config.ID); err != nil {
 		return err
 	}
-	if err := setFileSystem(rootfs); err != nil {
+	//要在chroot之前挂，挂载路径是相对宿主机的rootfs
+	if err := setMount(config.Rootfs); err != nil {
 		return err
 	}
-	if err := setMount(rootfs); err != nil {
+	if err := setFileSystem(config.Rootfs); err != nil {
+		return err
+	}
+
+	user, err := lookupUser(config.User)
+	if err != nil {
+		return err
+	}
+	env := config.Env
+	if !hasEnv(env, "HOME") {
+		env = append(env, "HOME="+user.Home)
+	}
+
+	dir := config.Dir
+	if dir == "" {
+		dir = "/"
+	}
+	//镜像里的WorkingDir不一定存在，docker也是直接建出来
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("fail to create working dir,%v", err)
+	}
+	if err := syscall.Chdir(dir); err != nil {
+		return fmt.Errorf("fail to chdir %s,%v", dir, err)
+	}
+	if config.Tty {
+		if err := setConsole(user); err != nil {
+			return err
+		}
+	}
+	if err := setUser(user); err != nil {
 		return err
 	}
 
-	return executeCommand(cmd)
+	return executeCommand(config.Args, env)
 
 }
 
-func ExecInContainer(ID string, rootfs string, cmd []string) error {
-	slog.Info("execute command", "containerID", ID, "command", cmd)
+func hasEnv(env []string, key string) bool {
+	for _, kv := range env {
+		if strings.HasPrefix(kv, key+"=") {
+			return true
+		}
+	}
+	return false
+}
 
-	if err := setHostName(ID); err != nil {
+// setConsole 开pty，master交给父进程，slave当成自己的控制终端和标准输入输出
+func setConsole(user *execUser) error {
+	socket := os.NewFile(4, "console-socket")
+	defer socket.Close()
+	master, slave, err := openPty()
+	if err != nil {
 		return err
 	}
-	if err := setFileSystem(rootfs); err != nil {
-		return err
+	defer slave.Close()
+	err = sendFd(socket, master)
+	master.Close()
+	if err != nil {
+		return fmt.Errorf("fail to send console,%v", err)
 	}
-	return executeCommand(cmd)
+	//换了用户也要能读写自己的终端
+	if err := slave.Chown(user.Uid, user.Gid); err != nil {
+		return fmt.Errorf("fail to chown pty,%v", err)
+	}
+	return setControllingTerminal(slave)
 }
 
-func InitContainer(rootfs string) error {
-	if err := setMount(rootfs); err != nil {
-		return fmt.Errorf("fail to mount rootfs,%v", err)
+// InitContainer 在容器的init进程里执行，从fd 3读父进程发过来的配置
+func InitContainer() error {
+	if os.Getenv(initPipeEnv) == "" {
+		return fmt.Errorf("init can only be called by easydocker itself")
 	}
-	return nil
+	//init的输出就是容器的输出，只留错误，不然日志里全是easydocker自己的东西
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError})))
+	pipe := os.NewFile(3, "init-pipe")
+	defer pipe.Close()
+
+	var config ContainerConfig
+	if err := json.NewDecoder(pipe).Decode(&config); err != nil {
+		return fmt.Errorf("fail to read init config,%v", err)
+	}
+	pipe.Close()
+	return InitProcess(&config)
 }
@@ -0,0 +1,145 @@
+package isolation
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// 容器的init进程把pty的master通过这个fd上的unix socket发回给父进程
+const consoleEnv = "_EASYDOCKER_CONSOLE"
+
+// openPty 在当前的根目录下开一对pty，要已经chroot进容器，用的是容器自己的devpts
+func openPty() (*os.File, *os.File, error) {
+	master, err := os.OpenFile("/dev/pts/ptmx", os.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("fail to open ptmx,%v", err)
+	}
+	if err := unix.IoctlSetPointerInt(int(master.Fd()), unix.TIOCSPTLCK, 0); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("fail to unlock pty,%v", err)
+	}
+	n, err := unix.IoctlGetInt(int(master.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("fail to get pty number,%v", err)
+	}
+	slave, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("fail to open pty slave,%v", err)
+	}
+	return master, slave, nil
+}
+
+// setControllingTerminal 新开一个session，pty当控制终端，再接到0 1 2上
+func setControllingTerminal(slave *os.File) error {
+	if _, err := unix.Setsid(); err != nil {
+		return fmt.Errorf("fail to setsid,%v", err)
+	}
+	if err := unix.IoctlSetInt(int(slave.Fd()), unix.TIOCSCTTY, 0); err != nil {
+		return fmt.Errorf("fail to set controlling terminal,%v", err)
+	}
+	for fd := 0; fd <= 2; fd++ {
+		if err := unix.Dup3(int(slave.Fd()), fd, 0); err != nil {
+			return fmt.Errorf("fail to dup pty,%v", err)
+		}
+	}
+	return nil
+}
+
+// sendFd recvFd 用SCM_RIGHTS在进程之间传fd
+func sendFd(socket *os.File, f *os.File) error {
+	rights := unix.UnixRights(int(f.Fd()))
+	return unix.Sendmsg(int(socket.Fd()), []byte(f.Name()), rights, nil, 0)
+}
+
+func recvFd(socket *os.File) (*os.File, error) {
+	name := make([]byte, 256)
+	oob := make([]byte, unix.CmsgSpace(4))
+	n, oobn, _, _, err := unix.Recvmsg(int(socket.Fd()), name, oob, unix.MSG_CMSG_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 && oobn == 0 {
+		return nil, fmt.Errorf("container init exited before sending console")
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) != 1 {
+		return nil, fmt.Errorf("bad console message")
+	}
+	fds, err := unix.ParseUnixRights(&msgs[0])
+	if err != nil || len(fds) != 1 {
+		return nil, fmt.Errorf("bad console message")
+	}
+	return os.NewFile(uintptr(fds[0]), string(name[:n])), nil
+}
+
+// IsTerminal stdin不是终端的时候就不切raw模式
+func IsTerminal(fd uintptr) bool {
+	_, err := unix.IoctlGetTermios(int(fd), unix.TCGETS)
+	return err == nil
+}
+
+// MakeRaw 和cfmakeraw一样，回车、Ctrl-C这些都原样交给容器里的终端处理
+// 返回的函数用来恢复原来的设置
+func MakeRaw(fd uintptr) (func(), error) {
+	old, err := unix.IoctlGetTermios(int(fd), unix.TCGETS)
+	if err != nil {
+		return nil, err
+	}
+	raw := *old
+	raw.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	raw.Oflag &^= unix.OPOST
+	raw.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	raw.Cflag &^= unix.CSIZE | unix.PARENB
+	raw.Cflag |= unix.CS8
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(int(fd), unix.TCSETS, &raw); err != nil {
+		return nil, err
+	}
+	return func() {
+		unix.IoctlSetTermios(int(fd), unix.TCSETS, old)
+	}, nil
+}
+
+// Winsize 终端的行数和列数
+type Winsize struct {
+	Rows uint16
+	Cols uint16
+}
+
+func GetWinsize(fd uintptr) (*Winsize, error) {
+	ws, err := unix.IoctlGetWinsize(int(fd), unix.TIOCGWINSZ)
+	if err != nil {
+		return nil, err
+	}
+	return &Winsize{Rows: ws.Row, Cols: ws.Col}, nil
+}
+
+// Resize 改master的窗口大小，内核会给前台进程组发SIGWINCH
+func Resize(master *os.File, size *Winsize) error {
+	return unix.IoctlSetWinsize(int(master.Fd()), unix.TIOCSWINSZ, &unix.Winsize{Row: size.Rows, Col: size.Cols})
+}
+
+// ConsoleReader 容器里的进程都退出之后读master会返回EIO，当成EOF
+func ConsoleReader(master *os.File) io.Reader {
+	return consoleReader{master}
+}
+
+type consoleReader struct {
+	f *os.File
+}
+
+func (r consoleReader) Read(p []byte) (int, error) {
+	n, err := r.f.Read(p)
+	if errors.Is(err, syscall.EIO) {
+		return n, io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,96 @@
+package isolation
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+type execUser struct {
+	Uid  int
+	Gid  int
+	Home string
+}
+
+// 在chroot之后调用，读的是容器里的/etc/passwd和/etc/group
+// spec的格式和docker一样: user, user:group, uid, uid:gid
+func lookupUser(spec string) (*execUser, error) {
+	u := &execUser{Home: "/"}
+	if spec == "" {
+		spec = "0"
+	}
+	name, group, hasGroup := strings.Cut(spec, ":")
+
+	if uid, err := strconv.Atoi(name); err == nil {
+		u.Uid = uid
+		//passwd里没有的uid和docker一样gid是0
+		u.Gid = 0
+		if entry := findEntry("/etc/passwd", func(f []string) bool { return len(f) > 3 && f[2] == name }); entry != nil {
+			u.Gid, _ = strconv.Atoi(entry[3])
+			if len(entry) > 5 {
+				u.Home = entry[5]
+			}
+		}
+	} else {
+		entry := findEntry("/etc/passwd", func(f []string) bool { return len(f) > 3 && f[0] == name })
+		if entry == nil {
+			return nil, fmt.Errorf("unable to find user %s", name)
+		}
+		u.Uid, _ = strconv.Atoi(entry[2])
+		u.Gid, _ = strconv.Atoi(entry[3])
+		if len(entry) > 5 {
+			u.Home = entry[5]
+		}
+	}
+
+	if hasGroup {
+		if gid, err := strconv.Atoi(group); err == nil {
+			u.Gid = gid
+		} else {
+			entry := findEntry("/etc/group", func(f []string) bool { return len(f) > 2 && f[0] == group })
+			if entry == nil {
+				return nil, fmt.Errorf("unable to find group %s", group)
+			}
+			u.Gid, _ = strconv.Atoi(entry[2])
+		}
+	}
+	return u, nil
+}
+
+func findEntry(file string, match func([]string) bool) []string {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if match(fields) {
+			return fields
+		}
+	}
+	return nil
+}
+
+// 先改gid再改uid，反过来就没权限改gid了
+func setUser(u *execUser) error {
+	if err := syscall.Setgroups([]int{u.Gid}); err != nil {
+		return fmt.Errorf("fail to set groups,%v", err)
+	}
+	if err := syscall.Setgid(u.Gid); err != nil {
+		return fmt.Errorf("fail to set gid,%v", err)
+	}
+	if err := syscall.Setuid(u.Uid); err != nil {
+		return fmt.Errorf("fail to set uid,%v", err)
+	}
+	return nil
+}
@@ -32,7 +32,7 @@ func SetBridge() error {
 	}
 
 	//配置ip
-	addr, err := netlink.ParseAddr("172.17.0.1/24")
+	addr, err := netlink.ParseAddr(fmt.Sprintf("%s/%d", Gateway, prefixLen))
 	if err != nil {
 		return fmt.Errorf("fail to parse addr,%v", err)
 	}
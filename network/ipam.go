@@ -0,0 +1,116 @@
+package network
+
+import (
+	"docker/storage"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path"
+)
+
+// 容器都在easydocker网桥的/24里，.0是网络地址，.1是网关，.255是广播地址
+const (
+	Gateway   = "172.17.0.1"
+	prefixLen = 24
+	firstHost = 2
+	lastHost  = 254
+)
+
+var (
+	ipamRoot = path.Join(storage.Root, "network")
+	ipamFile = path.Join(ipamRoot, "ipam.json")
+	ipamLock = path.Join(ipamRoot, "ipam.lock")
+)
+
+// ipam 已经分出去的地址，ip -> 容器ID
+type ipam struct {
+	Allocated map[string]string `json:"allocated"`
+}
+
+func loadIPAM() (*ipam, error) {
+	record := &ipam{Allocated: map[string]string{}}
+	data, err := os.ReadFile(ipamFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return record, nil
+		}
+		return nil, fmt.Errorf("fail to read ipam,%v", err)
+	}
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil, fmt.Errorf("fail to parse ipam,%v", err)
+	}
+	if record.Allocated == nil {
+		record.Allocated = map[string]string{}
+	}
+	return record, nil
+}
+
+// save 先写临时文件再rename，写到一半挂了也不会把记录弄坏
+func (r *ipam) save() error {
+	data, err := json.MarshalIndent(r, "", "	")
+	if err != nil {
+		return err
+	}
+	tmp := ipamFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("fail to save ipam,%v", err)
+	}
+	return os.Rename(tmp, ipamFile)
+}
+
+// withIPAM 加锁读出记录，fn改完之后存回去，多个容器同时启动也不会分到同一个地址
+func withIPAM(fn func(r *ipam) error) error {
+	lock, err := storage.Lock(ipamLock)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	record, err := loadIPAM()
+	if err != nil {
+		return err
+	}
+	if err := fn(record); err != nil {
+		return err
+	}
+	return record.save()
+}
+
+// AllocateIP 容器已经有地址的(重新start)还用原来的，不然从.2开始找第一个空闲的
+func AllocateIP(containerID string) (*net.IPNet, error) {
+	var ip net.IP
+	err := withIPAM(func(r *ipam) error {
+		for addr, id := range r.Allocated {
+			if id == containerID {
+				ip = net.ParseIP(addr)
+				return nil
+			}
+		}
+		gateway := net.ParseIP(Gateway).To4()
+		for host := firstHost; host <= lastHost; host++ {
+			candidate := net.IPv4(gateway[0], gateway[1], gateway[2], byte(host))
+			if _, used := r.Allocated[candidate.String()]; !used {
+				r.Allocated[candidate.String()] = containerID
+				ip = candidate
+				return nil
+			}
+		}
+		return fmt.Errorf("no available ip in %s/%d", Gateway, prefixLen)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(prefixLen, 32)}, nil
+}
+
+// ReleaseIP rm的时候把容器的地址还回去，没分过的也不算错
+func ReleaseIP(containerID string) error {
+	return withIPAM(func(r *ipam) error {
+		for addr, id := range r.Allocated {
+			if id == containerID {
+				delete(r.Allocated, addr)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,59 @@
+package network
+
+import (
+	"fmt"
+	"path"
+	"testing"
+)
+
+func useTempIPAM(t *testing.T) {
+	dir := t.TempDir()
+	oldFile, oldLock := ipamFile, ipamLock
+	ipamFile, ipamLock = path.Join(dir, "ipam.json"), path.Join(dir, "ipam.lock")
+	t.Cleanup(func() { ipamFile, ipamLock = oldFile, oldLock })
+}
+
+func TestAllocateIP(t *testing.T) {
+	useTempIPAM(t)
+	seen := map[string]string{}
+	for host := firstHost; host <= lastHost; host++ {
+		id := fmt.Sprintf("container%d", host)
+		ip, err := AllocateIP(id)
+		if err != nil {
+			t.Fatalf("allocate %d,%v", host, err)
+		}
+		if ones, _ := ip.Mask.Size(); ones != prefixLen {
+			t.Fatalf("mask %v", ip.Mask)
+		}
+		last := ip.IP.To4()[3]
+		if last < firstHost || last > lastHost {
+			t.Fatalf("allocated %s out of range", ip.IP)
+		}
+		if other, ok := seen[ip.IP.String()]; ok {
+			t.Fatalf("%s allocated to both %s and %s", ip.IP, other, id)
+		}
+		seen[ip.IP.String()] = id
+
+		//同一个容器再分还是原来的
+		again, err := AllocateIP(id)
+		if err != nil || !again.IP.Equal(ip.IP) {
+			t.Fatalf("allocate again %v,%v, want %s", again, err, ip.IP)
+		}
+	}
+	//.2到.254都用完了
+	if _, err := AllocateIP("full"); err == nil {
+		t.Fatal("allocate should fail when subnet is full")
+	}
+
+	released := "172.17.0.100"
+	if err := ReleaseIP(seen[released]); err != nil {
+		t.Fatal(err)
+	}
+	ip, err := AllocateIP("reuse")
+	if err != nil || ip.IP.String() != released {
+		t.Fatalf("allocate after release %v,%v, want %s", ip, err, released)
+	}
+	if err := ReleaseIP("not-allocated"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log/slog"
 	"net"
+	"runtime"
 
 	"github.com/vishvananda/netlink"
 	"github.com/vishvananda/netns"
@@ -15,10 +16,24 @@ type Network struct {
 	Subnet  string
 }
 
+// HostInterface 容器在宿主机这边的veth名字，网卡名最长15个字符
+func HostInterface(containerID string) string {
+	if len(containerID) > 8 {
+		containerID = containerID[:8]
+	}
+	return "veth_" + containerID
+}
+
 func SetNetwork(containerID string, pid int) error {
+	if err := SetBridge(); err != nil {
+		return err
+	}
+
 	//用veth pair来连接namespace
 	//主机接口
-	hostInterface := "veth_" + containerID[:8]
+	hostInterface := HostInterface(containerID)
+	//容器这一端先用临时名字，直接叫eth0会和宿主机的网卡撞名，进了容器的ns再改
+	peerInterface := "vethc" + hostInterface[4:]
 	//容器内部接口
 	containerInterface := "eth0"
 
@@ -27,33 +42,38 @@ func SetNetwork(containerID string, pid int) error {
 			Name:  hostInterface,
 			Flags: net.FlagUp, //创建后立即启用
 		},
-		PeerName: containerInterface, //接口是veth pair的另一端
+		PeerName: peerInterface, //接口是veth pair的另一端
+	}
+
+	//地址记在/var/lib/easydocker/network/ipam.json里，rm的时候才还回去
+	ip, err := AllocateIP(containerID)
+	if err != nil {
+		return err
 	}
 
-	var err error
 	//错误的话立刻删除veth
 	defer func() {
 		if err != nil {
-			slog.Warn("fail to clean veth")
+			slog.Warn("fail to set network, clean veth", "error", err)
 			netlink.LinkDel(veth)
 		}
 	}()
 
 	//注册并创建veth pair
-	if err := netlink.LinkAdd(veth); err != nil {
+	if err = netlink.LinkAdd(veth); err != nil {
 		slog.Error("fail to add veth")
 		return err
 	}
 
 	//通过name查找veth pair容器端接口
-	peer, err := netlink.LinkByName(containerInterface)
+	peer, err := netlink.LinkByName(peerInterface)
 	if err != nil {
 		slog.Error("fail to get peer")
 		return err
 	}
 
 	//接口移动到container的ns
-	if err := netlink.LinkSetNsPid(peer, pid); err != nil {
+	if err = netlink.LinkSetNsPid(peer, pid); err != nil {
 		slog.Error("fail to move peer")
 		return err
 	}
@@ -72,55 +92,131 @@ func SetNetwork(containerID string, pid int) error {
 		return fmt.Errorf("fail to set veth up")
 	}
 
-	nsHandle, err := netns.GetFromPid(pid)
-	if err != nil {
-		return fmt.Errorf("fail to get netns")
-	}
-	defer nsHandle.Close()
+	//剩下的都要在容器的netns里做
+	err = InNetns(pid, func() error {
+		peerNs, err := netlink.LinkByName(peerInterface)
+		if err != nil {
+			return fmt.Errorf("fail to get peer")
+		}
+		if err := netlink.LinkSetName(peerNs, containerInterface); err != nil {
+			return fmt.Errorf("fail to rename peer")
+		}
 
-	//方便恢复，先存一下
-	originNs, err := netns.Get()
+		//配置ip
+		if err := netlink.AddrAdd(peerNs, &netlink.Addr{IPNet: ip}); err != nil {
+			return fmt.Errorf("fail to add ip")
+		}
+
+		if err := netlink.LinkSetUp(peerNs); err != nil {
+			return fmt.Errorf("fail to set up")
+		}
+		if lo, err := netlink.LinkByName("lo"); err == nil {
+			netlink.LinkSetUp(lo)
+		}
+
+		defaultGateway := net.ParseIP(Gateway)
+		route := &netlink.Route{
+			LinkIndex: peerNs.Attrs().Index,
+			Dst:       nil, //nil的话是默认的0.0.0.0/0
+			Gw:        defaultGateway,
+		}
+		if err := netlink.RouteAdd(route); err != nil {
+			return fmt.Errorf("fail to add route")
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("fail to get netns")
+		return err
 	}
-	defer originNs.Close()
-	defer netns.Set(originNs) //切回原来的
 
-	//ns换过去
-	if err = netns.Set(nsHandle); err != nil {
-		return fmt.Errorf("fail to set netns")
-	}
+	slog.Info("created successful", "bridge", containerID)
+	return nil
+}
 
-	peerNs, err := netlink.LinkByName(containerInterface)
+// Endpoint 容器这一端的网络信息，直接从容器的netns里读
+type Endpoint struct {
+	Bridge        string `json:"bridge"`
+	HostInterface string `json:"host_interface"`
+	IPAddress     string `json:"ip_address"`
+	PrefixLen     int    `json:"prefix_len"`
+	Gateway       string `json:"gateway"`
+	MacAddress    string `json:"mac_address"`
+	SandboxKey    string `json:"sandbox_key"`
+}
+
+// InNetns 在pid所在的netns里执行fn，执行完切回来
+func InNetns(pid int, fn func() error) error {
+	nsHandle, err := netns.GetFromPid(pid)
 	if err != nil {
-		return fmt.Errorf("fail to get peer")
+		return fmt.Errorf("fail to get netns,%v", err)
 	}
+	defer nsHandle.Close()
 
-	//docker的默认网段是172.17.0.0/16
-	//这边选择最后一段在2-255中间是为了避免和172.17.0.1冲突嗯
-	ip := fmt.Sprintf("172.17.0.%d/16", pid%254+2)
-	addr, err := netlink.ParseAddr(ip)
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	originNs, err := netns.Get()
 	if err != nil {
-		return fmt.Errorf("fail to parse ip")
-	}
-	//配置ip
-	if err = netlink.AddrAdd(peerNs, addr); err != nil {
-		return fmt.Errorf("fail to add ip")
+		return fmt.Errorf("fail to get netns,%v", err)
 	}
+	defer originNs.Close()
+	defer netns.Set(originNs)
 
-	if err = netlink.LinkSetUp(peerNs); err != nil {
-		return fmt.Errorf("fail to set up")
+	if err := netns.Set(nsHandle); err != nil {
+		return fmt.Errorf("fail to set netns,%v", err)
 	}
+	return fn()
+}
 
-	defaultGateway := net.ParseIP("172.17.0.1")
-	route := &netlink.Route{
-		Dst: nil, //nil的话是默认的0.0.0.0/0
-		Gw:  defaultGateway,
+func GetEndpoint(containerID string, pid int) (*Endpoint, error) {
+	ep := &Endpoint{
+		Bridge:        "easydocker",
+		HostInterface: HostInterface(containerID),
+		SandboxKey:    fmt.Sprintf("/proc/%d/ns/net", pid),
 	}
-	if err = netlink.RouteAdd(route); err != nil {
-		return fmt.Errorf("fail to add route")
+	err := InNetns(pid, func() error {
+		link, err := netlink.LinkByName("eth0")
+		if err != nil {
+			return err
+		}
+		ep.MacAddress = link.Attrs().HardwareAddr.String()
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+		if err != nil {
+			return err
+		}
+		if len(addrs) > 0 {
+			ep.IPAddress = addrs[0].IP.String()
+			ep.PrefixLen, _ = addrs[0].Mask.Size()
+		}
+		routes, err := netlink.RouteList(link, netlink.FAMILY_V4)
+		if err != nil {
+			return err
+		}
+		for _, route := range routes {
+			//默认路由有的版本Dst是nil，有的是0.0.0.0/0
+			if route.Gw != nil && (route.Dst == nil || route.Dst.IP.IsUnspecified()) {
+				ep.Gateway = route.Gw.String()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return ep, nil
+}
 
-	slog.Info("created successful", "bridge", containerID)
-	return nil
+// GetNetStats 容器eth0收发的字节数，容器里看到的收就是容器收到的
+func GetNetStats(pid int) (rx uint64, tx uint64, err error) {
+	err = InNetns(pid, func() error {
+		link, err := netlink.LinkByName("eth0")
+		if err != nil {
+			return err
+		}
+		if s := link.Attrs().Statistics; s != nil {
+			rx, tx = s.RxBytes, s.TxBytes
+		}
+		return nil
+	})
+	return rx, tx, err
 }
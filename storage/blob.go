@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var digestRegexp = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// BlobStore 按digest存内容，目录结构 root/sha256/<hex>
+// 写入先写到<hex>.partial，校验过了再rename，所以存在的blob一定是完整的
+type BlobStore struct {
+	root string
+}
+
+func NewBlobStore(root string) *BlobStore {
+	return &BlobStore{root: root}
+}
+
+// path digest是从registry拿来的，不校验的话sha256:../../x就能写到外面去
+func (b *BlobStore) path(digest string) (string, error) {
+	if !digestRegexp.MatchString(digest) {
+		return "", fmt.Errorf("invalid digest %q", digest)
+	}
+	return path.Join(b.root, "sha256", strings.TrimPrefix(digest, "sha256:")), nil
+}
+
+func (b *BlobStore) Has(digest string) bool {
+	p, err := b.path(digest)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(p)
+	return err == nil
+}
+
+func (b *BlobStore) Open(digest string) (*os.File, error) {
+	p, err := b.path(digest)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+// Lock 同一个blob同时只让一个进程写，跨进程有效
+func (b *BlobStore) Lock(digest string) (*FileLock, error) {
+	p, err := b.path(digest)
+	if err != nil {
+		return nil, err
+	}
+	return Lock(p + ".lock")
+}
+
+// Partial 上次没写完的字节数，Ingest会从这里接着写
+func (b *BlobStore) Partial(digest string) int64 {
+	p, err := b.path(digest)
+	if err != nil {
+		return 0
+	}
+	info, err := os.Stat(p + ".partial")
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// Abort 丢掉没写完的部分，下次Ingest从头开始
+func (b *BlobStore) Abort(digest string) error {
+	p, err := b.path(digest)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p + ".partial"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Ingest r里是Partial(digest)之后剩下的内容
+// 中途断了.partial会留着，下次接着写；内容对不上就删掉
+func (b *BlobStore) Ingest(digest string, size int64, r io.Reader) error {
+	p, err := b.path(digest)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+		return err
+	}
+	partial := p + ".partial"
+	f, err := os.OpenFile(partial, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("fail to create blob,%v", err)
+	}
+	defer f.Close()
+
+	//已经写好的部分也要算进hash里
+	hash := sha256.New()
+	offset, err := io.Copy(hash, f)
+	if err != nil {
+		return err
+	}
+	if offset > size {
+		f.Close()
+		os.Remove(partial)
+		return fmt.Errorf("partial blob is larger than %d", size)
+	}
+
+	n, err := io.Copy(f, io.TeeReader(r, hash))
+	if err != nil {
+		return fmt.Errorf("fail to write blob,%v", err)
+	}
+	if offset+n != size {
+		return fmt.Errorf("size do not match,want %d,got %d", size, offset+n)
+	}
+	if got := fmt.Sprintf("sha256:%x", hash.Sum(nil)); got != digest {
+		f.Close()
+		os.Remove(partial)
+		return fmt.Errorf("hash do not match,want %s,got %s", digest, got)
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return os.Rename(partial, p)
+}
+
+func (b *BlobStore) Delete(digest string) error {
+	p, err := b.path(digest)
+	if err != nil {
+		return err
+	}
+	for _, file := range []string{p, p + ".partial"} {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
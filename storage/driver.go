@@ -1,41 +1,78 @@
 package storage
 
 import (
+	"fmt"
 	"os"
 	"path"
+	"strings"
 )
 
+// StorageDriver 管容器的rootfs，镜像层都在LayerRoot下面，按从下到上的顺序传进来
 type StorageDriver interface {
+	Name() string
 	Init() error
-	Create(name string) error
-	Remove(name string) error
-	Exists(name string) bool
+	//准备容器的读写层，layers是镜像每一层解压后的目录
+	Create(id string, layers []string) error
+	//返回容器的rootfs
+	Mount(id string) (string, error)
+	Unmount(id string) error
+	Remove(id string) error
+	Exists(id string) bool
 }
 
-type FileDriver struct{}
+const (
+	DriverOverlay = "overlay"
+	DriverVfs     = "vfs"
+)
+
+// DefaultDriver 空的话自动选，支持overlay就用overlay
+var DefaultDriver = ""
 
-func (f *FileDriver) Init() error {
-	dir := []string{ImageRoot, ContainRoot}
-	for _, dir := range dir {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			return err
+// GetDriver 容器记录里存了driver名字，之后的操作都要用同一个
+func GetDriver(name string) (StorageDriver, error) {
+	if name == "" {
+		name = DefaultDriver
+	}
+	if name == "" {
+		name = DriverVfs
+		if overlaySupported() {
+			name = DriverOverlay
 		}
 	}
-	return nil
+
+	var driver StorageDriver
+	switch name {
+	case DriverOverlay:
+		driver = &OverlayDriver{}
+	case DriverVfs:
+		driver = &VfsDriver{}
+	default:
+		return nil, fmt.Errorf("unknown storage driver %s", name)
+	}
+	if err := driver.Init(); err != nil {
+		return nil, fmt.Errorf("fail to init storage driver %s,%v", name, err)
+	}
+	return driver, nil
 }
 
-func (f *FileDriver) Create(name string) error {
-	imagePath := path.Join(ImageRoot, name)
-	return os.MkdirAll(imagePath, 0755)
+func overlaySupported() bool {
+	data, err := os.ReadFile("/proc/filesystems")
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasSuffix(line, "\toverlay") {
+			return true
+		}
+	}
+	return false
 }
 
-func (f *FileDriver) Remove(name string) error {
-	imagePath := path.Join(ImageRoot, name)
-	return os.RemoveAll(imagePath)
+// LayerPath 每一层解压到单独的目录，内容用overlay的whiteout格式
+func LayerPath(digest string) string {
+	return path.Join(LayerRoot, strings.TrimPrefix(digest, "sha256:"), "diff")
 }
 
-func (f *FileDriver) Exists(name string) bool {
-	imagePath := path.Join(ImageRoot, name)
-	_, err := os.Stat(imagePath)
-	return !os.IsNotExist(err)
+func containerPath(id string) string {
+	return path.Join(ContainRoot, id)
 }
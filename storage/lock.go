@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"syscall"
+)
+
+// FileLock 基于flock，进程退出内核会自动释放，不会留下死锁
+type FileLock struct {
+	f *os.File
+}
+
+// Lock 阻塞直到拿到锁，多个easydocker进程之间也是互斥的
+func Lock(lockPath string) (*FileLock, error) {
+	if err := os.MkdirAll(path.Dir(lockPath), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("fail to open lock,%v", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("fail to lock %s,%v", lockPath, err)
+	}
+	return &FileLock{f: f}, nil
+}
+
+func (l *FileLock) Unlock() error {
+	if l == nil || l.f == nil {
+		return nil
+	}
+	defer l.f.Close()
+	return syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN)
+}
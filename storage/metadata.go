@@ -13,12 +13,16 @@ var (
 	ImageRoot   = path.Join(Root, "images")
 	ContainRoot = path.Join(Root, "contains")
 	BlobRoot    = path.Join(Root, "blobs")
+	LayerRoot   = path.Join(Root, "layers")
 )
 
 type ImageMetadata struct {
-	Name    string `json:"name"`
-	Version string `json:"version"`
-	Size    int64  `json:"size"`
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Version string    `json:"version"`
+	Size    int64     `json:"size"`
+	Digest  string    `json:"digest"`
+	Created time.Time `json:"created"`
 }
 
 type ContainerMetadata struct {
@@ -30,7 +34,7 @@ type ContainerMetadata struct {
 }
 
 func SaveImageMetadata(data *ImageMetadata) error {
-	imagePath := path.Join(ImageRoot, data.Name)
+	imagePath := ImagePath(data.ID)
 	metadataPath := path.Join(imagePath, "metadata.json")
 	if err := os.MkdirAll(imagePath, 0755); err != nil {
 		return err
@@ -54,8 +58,8 @@ func SaveImageMetadata(data *ImageMetadata) error {
 	return nil
 }
 
-func LoadImageMetadata(name string) (*ImageMetadata, error) {
-	metadataPath := ImageRoot + name + "metadata.json"
+func LoadImageMetadata(id string) (*ImageMetadata, error) {
+	metadataPath := path.Join(ImagePath(id), "metadata.json")
 	data, err := os.ReadFile(metadataPath)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// OverlayDriver 镜像层当lowerdir，每个容器自己一个upper和work
+// 目录结构：ContainRoot/<id>/{lower,upper,work,rootfs}
+type OverlayDriver struct{}
+
+func (o *OverlayDriver) Name() string {
+	return DriverOverlay
+}
+
+func (o *OverlayDriver) Init() error {
+	for _, dir := range []string{ContainRoot, LayerRoot} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *OverlayDriver) Create(id string, layers []string) error {
+	if len(layers) == 0 {
+		return fmt.Errorf("overlay needs at least one layer")
+	}
+	dir := containerPath(id)
+	if err := CreateDir(dir, []string{"upper", "work", "rootfs"}); err != nil {
+		return err
+	}
+	//lower文件里一行一层，从下到上
+	return os.WriteFile(path.Join(dir, "lower"), []byte(strings.Join(layers, "\n")), 0644)
+}
+
+func (o *OverlayDriver) Mount(id string) (string, error) {
+	dir := containerPath(id)
+	rootfs := path.Join(dir, "rootfs")
+	if mounted(rootfs) {
+		return rootfs, nil
+	}
+
+	data, err := os.ReadFile(path.Join(dir, "lower"))
+	if err != nil {
+		return "", fmt.Errorf("fail to read lower,%v", err)
+	}
+	layers := strings.Split(strings.TrimSpace(string(data)), "\n")
+	//lowerdir最左边的是最上层，和存的顺序反过来
+	lower := make([]string, 0, len(layers))
+	for i := len(layers) - 1; i >= 0; i-- {
+		lower = append(lower, layers[i])
+	}
+
+	options := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s",
+		strings.Join(lower, ":"), path.Join(dir, "upper"), path.Join(dir, "work"))
+	if len(options) >= os.Getpagesize() {
+		return "", fmt.Errorf("too many layers for overlay mount options")
+	}
+	if err := unix.Mount("overlay", rootfs, "overlay", 0, options); err != nil {
+		return "", fmt.Errorf("fail to mount overlay,%v", err)
+	}
+	return rootfs, nil
+}
+
+func (o *OverlayDriver) Unmount(id string) error {
+	rootfs := path.Join(containerPath(id), "rootfs")
+	if !mounted(rootfs) {
+		return nil
+	}
+	if err := unix.Unmount(rootfs, unix.MNT_DETACH); err != nil {
+		return fmt.Errorf("fail to unmount overlay,%v", err)
+	}
+	return nil
+}
+
+func (o *OverlayDriver) Remove(id string) error {
+	if err := o.Unmount(id); err != nil {
+		return err
+	}
+	return os.RemoveAll(containerPath(id))
+}
+
+func (o *OverlayDriver) Exists(id string) bool {
+	_, err := os.Stat(path.Join(containerPath(id), "lower"))
+	return err == nil
+}
+
+// mounted 看/proc/self/mountinfo第5列有没有这个挂载点
+func mounted(target string) bool {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 4 && fields[4] == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// 和docker的repositories.json一样，仓库名 -> (引用 -> 镜像ID)
+var RepositoriesFile = path.Join(ImageRoot, "repositories.json")
+
+type ReferenceStore struct {
+	Repositories map[string]map[string]string `json:"Repositories"`
+}
+
+func LoadReferenceStore() (*ReferenceStore, error) {
+	store := &ReferenceStore{Repositories: map[string]map[string]string{}}
+	data, err := os.ReadFile(RepositoriesFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return store, nil
+		}
+		return nil, fmt.Errorf("fail to read repositories,%v", err)
+	}
+	if err := json.Unmarshal(data, store); err != nil {
+		return nil, fmt.Errorf("fail to parse repositories,%v", err)
+	}
+	if store.Repositories == nil {
+		store.Repositories = map[string]map[string]string{}
+	}
+	return store, nil
+}
+
+// Save 先写临时文件再rename，别的进程不会读到写了一半的
+func (s *ReferenceStore) Save() error {
+	if err := os.MkdirAll(path.Dir(RepositoriesFile), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "	")
+	if err != nil {
+		return err
+	}
+	tmp := RepositoriesFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, RepositoriesFile)
+}
+
+// UpdateReferenceStore 读改写整个过程加锁，不然两个进程同时pull会丢引用
+func UpdateReferenceStore(fn func(*ReferenceStore) error) error {
+	lock, err := Lock(RepositoriesFile + ".lock")
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	store, err := LoadReferenceStore()
+	if err != nil {
+		return err
+	}
+	if err := fn(store); err != nil {
+		return err
+	}
+	return store.Save()
+}
+
+func (s *ReferenceStore) Get(repo, ref string) (string, bool) {
+	id, ok := s.Repositories[repo][ref]
+	return id, ok
+}
+
+func (s *ReferenceStore) Add(repo, ref, id string) {
+	refs, ok := s.Repositories[repo]
+	if !ok {
+		refs = map[string]string{}
+		s.Repositories[repo] = refs
+	}
+	refs[ref] = id
+}
+
+func (s *ReferenceStore) Delete(repo, ref string) bool {
+	refs, ok := s.Repositories[repo]
+	if !ok {
+		return false
+	}
+	if _, ok := refs[ref]; !ok {
+		return false
+	}
+	delete(refs, ref)
+	if len(refs) == 0 {
+		delete(s.Repositories, repo)
+	}
+	return true
+}
+
+// References 指向这个镜像的所有引用，按字母排好序
+func (s *ReferenceStore) References(id string) []string {
+	var refs []string
+	for _, repo := range s.Repositories {
+		for ref, target := range repo {
+			if target == id {
+				refs = append(refs, ref)
+			}
+		}
+	}
+	sort.Strings(refs)
+	return refs
+}
+
+// ImagePath 镜像按ID存，目录名去掉sha256:前缀
+func ImagePath(id string) string {
+	return path.Join(ImageRoot, strings.TrimPrefix(id, "sha256:"))
+}
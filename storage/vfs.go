@@ -0,0 +1,240 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	overlayOpaqueXattr = "trusted.overlay.opaque"
+	overlayXattrPrefix = "trusted.overlay."
+)
+
+// VfsDriver 不支持overlay的时候用，把每一层依次复制到容器的rootfs里
+// 层里的whiteout是overlay格式的，复制的时候按删除处理
+type VfsDriver struct{}
+
+func (v *VfsDriver) Name() string {
+	return DriverVfs
+}
+
+func (v *VfsDriver) Init() error {
+	for _, dir := range []string{ContainRoot, LayerRoot} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *VfsDriver) Create(id string, layers []string) error {
+	rootfs := path.Join(containerPath(id), "rootfs")
+	if err := os.MkdirAll(rootfs, 0755); err != nil {
+		return err
+	}
+	for _, layer := range layers {
+		if err := copyLayer(layer, rootfs); err != nil {
+			return fmt.Errorf("fail to copy layer %s,%v", layer, err)
+		}
+	}
+	return nil
+}
+
+func (v *VfsDriver) Mount(id string) (string, error) {
+	rootfs := path.Join(containerPath(id), "rootfs")
+	if _, err := os.Stat(rootfs); err != nil {
+		return "", err
+	}
+	return rootfs, nil
+}
+
+func (v *VfsDriver) Unmount(id string) error {
+	return nil
+}
+
+func (v *VfsDriver) Remove(id string) error {
+	return os.RemoveAll(containerPath(id))
+}
+
+func (v *VfsDriver) Exists(id string) bool {
+	_, err := os.Stat(path.Join(containerPath(id), "rootfs"))
+	return err == nil
+}
+
+// copyLayer 效果和overlay合并一样：
+// 0/0的字符设备是whiteout，带opaque的目录要先清空，目录盖掉非目录
+// WalkDir先走父目录，所以dst里的父目录一定已经是真的目录，不会跟着软链接跑出去
+// 层里的硬链接复制完还是硬链接，不然每个链接都多占一份空间
+func copyLayer(src, dst string) error {
+	var dirs []string
+	//(dev,ino) -> 第一次复制到的位置
+	copied := map[[2]uint64]string{}
+	err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, p)
+		target := path.Join(dst, rel)
+		info, err := os.Lstat(p)
+		if err != nil {
+			return err
+		}
+		stat := info.Sys().(*syscall.Stat_t)
+
+		if rel == "." {
+			return nil
+		}
+		if info.Mode()&os.ModeCharDevice != 0 && info.Mode()&os.ModeDevice != 0 && stat.Rdev == 0 {
+			return os.RemoveAll(target)
+		}
+
+		existing, err := os.Lstat(target)
+		exists := err == nil
+		if exists && !(existing.IsDir() && info.IsDir()) {
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+			exists = false
+		}
+
+		switch {
+		case info.IsDir():
+			if exists && isOpaque(p) {
+				if err := os.RemoveAll(target); err != nil {
+					return err
+				}
+				exists = false
+			}
+			if !exists {
+				if err := os.Mkdir(target, 0755); err != nil {
+					return err
+				}
+			}
+			dirs = append(dirs, rel)
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			key := [2]uint64{uint64(stat.Dev), stat.Ino}
+			if first, ok := copied[key]; ok {
+				return os.Link(first, target)
+			}
+			if err := copyFile(p, target); err != nil {
+				return err
+			}
+			if stat.Nlink > 1 {
+				copied[key] = target
+			}
+		default:
+			if err := unix.Mknod(target, stat.Mode, int(stat.Rdev)); err != nil {
+				return err
+			}
+		}
+		return copyAttrs(p, target, info, stat)
+	})
+	if err != nil {
+		return err
+	}
+
+	//目录里写完东西mtime会变，最后倒着再设置一遍
+	for i := len(dirs) - 1; i >= 0; i-- {
+		info, err := os.Lstat(path.Join(src, dirs[i]))
+		if err != nil {
+			return err
+		}
+		if err := copyTimes(path.Join(dst, dirs[i]), info.Sys().(*syscall.Stat_t)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isOpaque(dir string) bool {
+	buf := make([]byte, 1)
+	n, err := unix.Lgetxattr(dir, overlayOpaqueXattr, buf)
+	return err == nil && n == 1 && buf[0] == 'y'
+}
+
+func copyFile(src, dst string) error {
+	from, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer from.Close()
+
+	to, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|unix.O_NOFOLLOW, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(to, from); err != nil {
+		to.Close()
+		return err
+	}
+	return to.Close()
+}
+
+// copyAttrs 属主、权限、xattr、时间都要带过去，overlay自己的xattr不复制
+func copyAttrs(src, dst string, info os.FileInfo, stat *syscall.Stat_t) error {
+	if err := os.Lchown(dst, int(stat.Uid), int(stat.Gid)); err != nil {
+		return err
+	}
+	if err := copyXattrs(src, dst); err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		if err := unix.Fchmodat(unix.AT_FDCWD, dst, stat.Mode&07777, 0); err != nil {
+			return err
+		}
+	}
+	return copyTimes(dst, stat)
+}
+
+func copyXattrs(src, dst string) error {
+	size, err := unix.Llistxattr(src, nil)
+	if err != nil || size <= 0 {
+		return nil
+	}
+	buf := make([]byte, size)
+	size, err = unix.Llistxattr(src, buf)
+	if err != nil {
+		return nil
+	}
+	for _, name := range strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00") {
+		if name == "" || strings.HasPrefix(name, overlayXattrPrefix) {
+			continue
+		}
+		n, err := unix.Lgetxattr(src, name, nil)
+		if err != nil {
+			continue
+		}
+		value := make([]byte, n)
+		if n, err = unix.Lgetxattr(src, name, value); err != nil {
+			continue
+		}
+		if err := unix.Lsetxattr(dst, name, value[:n], 0); err != nil && err != unix.ENOTSUP {
+			return fmt.Errorf("fail to set xattr %s,%v", name, err)
+		}
+	}
+	return nil
+}
+
+func copyTimes(dst string, stat *syscall.Stat_t) error {
+	ts := []unix.Timespec{
+		unix.NsecToTimespec(stat.Atim.Nano()),
+		unix.NsecToTimespec(stat.Mtim.Nano()),
+	}
+	return unix.UtimesNanoAt(unix.AT_FDCWD, dst, ts, unix.AT_SYMLINK_NOFOLLOW)
+}